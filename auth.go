@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Header the clients send their API key in
+const apiKeyHeader = "X-API-Key"
+
+// Keys used to pass the authenticated client from the middleware to the handlers
+const (
+	clientIDKey = "clientId"
+	scopesKey   = "scopes"
+	tenantKey   = "tenant"
+)
+
+// Scopes that can be granted to API keys and tokens
+const (
+	scopeReceiptsWrite = "receipts:write"
+	scopeReceiptsRead  = "receipts:read"
+	// admin is allowed on every route
+	scopeAdmin = "admin"
+)
+
+// Scopes given to API keys that do not list their own
+var defaultAPIKeyScopes = []string{scopeReceiptsWrite, scopeReceiptsRead}
+
+// APIKey binds the hash of a key to the client it was issued to
+type APIKey struct {
+	ClientID string   `json:"clientId"`
+	KeyHash  string   `json:"keyHash"`
+	Scopes   []string `json:"scopes"`
+	// Tenant is the partner brand the client belongs to, it picks the rule overrides for its receipts
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// apiKeyStore looks up the client for a key, only the SHA-256 hashes of the keys are kept
+type apiKeyStore struct {
+	keys map[string]APIKey
+}
+
+// hashAPIKey returns the hex SHA-256 hash of the key, this is what is stored in the key file
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadAPIKeys reads the JSON list of hashed keys at path
+func loadAPIKeys(path string) (*apiKeyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	store := &apiKeyStore{keys: make(map[string]APIKey)}
+	for _, key := range keys {
+		if key.ClientID == "" || len(key.KeyHash) != sha256.Size*2 {
+			return nil, errors.New("api key entries need a clientId and a hex SHA-256 keyHash")
+		}
+		if len(key.Scopes) == 0 {
+			key.Scopes = defaultAPIKeyScopes
+		}
+		store.keys[strings.ToLower(key.KeyHash)] = key
+	}
+	return store, nil
+}
+
+// lookup returns the entry for the key
+func (s *apiKeyStore) lookup(key string) (APIKey, bool) {
+	entry, ok := s.keys[hashAPIKey(key)]
+	return entry, ok
+}
+
+// authenticate accepts either a bearer token or an API key and records the client and its scopes for the handlers.
+// keys or tokens may be nil when that method is not configured
+func authenticate(keys *apiKeyStore, tokens *jwtVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authorization := c.GetHeader("Authorization"); tokens != nil && authorization != "" {
+			token, found := strings.CutPrefix(authorization, "Bearer ")
+			claims, err := tokens.verify(token)
+			if !found || err != nil {
+				if err != nil {
+					c.Set(authErrorKey, err.Error())
+				}
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				c.String(http.StatusUnauthorized, "Missing or invalid bearer token.")
+				c.Abort()
+				return
+			}
+			// The subject of the token owns the receipts it submits
+			c.Set(clientIDKey, claims.Subject)
+			c.Set(scopesKey, claims.scopes())
+			c.Set(tenantKey, claims.Tenant)
+			c.Next()
+			return
+		}
+
+		if keys != nil {
+			if entry, ok := keys.lookup(c.GetHeader(apiKeyHeader)); ok {
+				c.Set(clientIDKey, entry.ClientID)
+				c.Set(scopesKey, entry.Scopes)
+				c.Set(tenantKey, entry.Tenant)
+				c.Next()
+				return
+			}
+		}
+
+		if tokens != nil {
+			c.Header("WWW-Authenticate", "Bearer")
+		}
+		c.String(http.StatusUnauthorized, "Missing or invalid credentials.")
+		c.Abort()
+	}
+}
+
+// requireScope rejects authenticated requests that were not granted the scope or admin.
+// When authentication is turned off no scopes are recorded and every request is allowed
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get(scopesKey)
+		if !ok {
+			c.Next()
+			return
+		}
+		scopes := value.([]string)
+		if !slices.Contains(scopes, scope) && !slices.Contains(scopes, scopeAdmin) {
+			c.String(http.StatusForbidden, "Missing the "+scope+" scope.")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requestClient returns the authenticated client, empty when authentication is turned off
+func requestClient(c *gin.Context) string {
+	return c.GetString(clientIDKey)
+}
+
+// requestTenant returns the tenant of the authenticated client, empty if it has none
+func requestTenant(c *gin.Context) string {
+	return c.GetString(tenantKey)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeAPIKeyFile writes a key file with one key per client and returns its path
+func writeAPIKeyFile(t *testing.T, keys map[string]string) string {
+	var entries []APIKey
+	for clientID, key := range keys {
+		entries = append(entries, APIKey{ClientID: clientID, KeyHash: hashAPIKey(key)})
+	}
+	data, _ := json.Marshal(entries)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newAuthRouter creates a router that requires the keys "key-a" and "key-b"
+func newAuthRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	cfg := defaultConfig()
+	cfg.Auth.APIKeysFile = writeAPIKeyFile(t, map[string]string{"client-a": "key-a", "client-b": "key-b"})
+	var buf bytes.Buffer
+	router, err := setupRouter(cfg, newTestLogger(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+// TestHashAPIKey
+func TestHashAPIKey(t *testing.T) {
+	assert.Equal(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", hashAPIKey("foo"))
+}
+
+// TestLoadAPIKeysInvalidHash
+func TestLoadAPIKeysInvalidHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(path, []byte(`[{"clientId": "client-a", "keyHash": "plaintext-key"}]`), 0600)
+	_, err := loadAPIKeys(path)
+	assert.Error(t, err)
+}
+
+// TestAPIKeyAuthMissingKey
+func TestAPIKeyAuthMissingKey(t *testing.T) {
+	setup()
+	router := newAuthRouter(t)
+
+	w := httptest.NewRecorder()
+	jsonbytes, _ := json.Marshal(validReceipt1)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(jsonbytes))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(jsonbytes))
+	req.Header.Set(apiKeyHeader, "not-a-key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, receiptsMap)
+
+	teardown()
+}
+
+// TestAPIKeyAuthScopesReceiptsToClient
+func TestAPIKeyAuthScopesReceiptsToClient(t *testing.T) {
+	setup()
+	router := newAuthRouter(t)
+
+	w := httptest.NewRecorder()
+	jsonbytes, _ := json.Marshal(validReceipt1)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(jsonbytes))
+	req.Header.Set(apiKeyHeader, "key-a")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "client-a", receiptsMap["Receipt1"].Owner)
+
+	// The submitting client can read the points
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/receipts/Receipt1/points", nil)
+	req.Header.Set(apiKeyHeader, "key-a")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Another client gets the same response as for an unknown id
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/receipts/Receipt1/points", nil)
+	req.Header.Set(apiKeyHeader, "key-b")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "No receipt found for that ID.", w.Body.String())
+
+	teardown()
+}
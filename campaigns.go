@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Layout of the campaign start and end, compared with the purchase date and time of the receipt
+const campaignTimeLayout = "2006-01-02T15:04"
+
+// Campaign gives extra points to receipts that match it during a time window
+type Campaign struct {
+	ID   string `json:"id"`
+	Name string `json:"name" binding:"required,max=256"`
+	// Start and End are inclusive and use the campaignTimeLayout
+	Start string `json:"start" binding:"required"`
+	End   string `json:"end" binding:"required"`
+	// Retailer matches the printed or the canonical retailer name, ignoring case and surrounding spaces
+	Retailer string `json:"retailer,omitempty"`
+	// ItemMatch matches receipts with an item description that contains it, ignoring case
+	ItemMatch string `json:"itemMatch,omitempty"`
+	// Multiplier gives (Multiplier - 1) times the base points extra, so 2 is double points
+	Multiplier float64 `json:"multiplier,omitempty"`
+	Bonus      int64   `json:"bonus,omitempty"`
+	// MaxBonus caps the extra points per receipt, 0 for no cap
+	MaxBonus int64 `json:"maxBonus,omitempty"`
+	// Stackable campaigns all apply, of the other campaigns only the one giving the most points does
+	Stackable bool `json:"stackable"`
+	// Tenant is the tenant of the admin that created the campaign, it only applies to that tenant's receipts
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// CampaignBonus is the extra points one campaign gave a receipt
+type CampaignBonus struct {
+	CampaignID string `json:"campaignId"`
+	Name       string `json:"name"`
+	Points     int64  `json:"points"`
+}
+
+// validate checks the time window and that the campaign gives something
+func (campaign Campaign) validate() error {
+	start, err := parseCampaignTime(campaign.Start)
+	if err != nil {
+		return errors.New("start must use the layout " + campaignTimeLayout)
+	}
+	end, err := parseCampaignTime(campaign.End)
+	if err != nil {
+		return errors.New("end must use the layout " + campaignTimeLayout)
+	}
+	if end.Before(start) {
+		return errors.New("end is before start")
+	}
+	if campaign.Multiplier != 0 && campaign.Multiplier < 1 {
+		return errors.New("multiplier must be at least 1")
+	}
+	if campaign.Bonus < 0 || campaign.MaxBonus < 0 {
+		return errors.New("bonus and maxBonus can not be negative")
+	}
+	if campaign.Multiplier <= 1 && campaign.Bonus == 0 {
+		return errors.New("the campaign needs a multiplier or a bonus")
+	}
+	return nil
+}
+
+// parseCampaignTime parses a campaign start or end
+func parseCampaignTime(value string) (time.Time, error) {
+	return time.Parse(campaignTimeLayout, value)
+}
+
+// matches reports whether the receipt was bought during the campaign and passes its predicates
+func (campaign Campaign) matches(receipt Receipt) bool {
+	if campaign.Tenant != receipt.Tenant {
+		return false
+	}
+	purchased := receipt.PurchaseDate + "T" + receipt.PurchaseTime
+	// Both use the same fixed width layout, so they compare as strings
+	if purchased < campaign.Start || purchased > campaign.End {
+		return false
+	}
+	if campaign.Retailer != "" && !receipt.sameRetailer(campaign.Retailer) {
+		return false
+	}
+	if campaign.ItemMatch != "" {
+		match := strings.ToLower(campaign.ItemMatch)
+		found := false
+		for _, item := range receipt.Items {
+			if strings.Contains(strings.ToLower(item.ShortDescription), match) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// bonus returns the extra points the campaign gives on top of the base points
+func (campaign Campaign) bonus(base int64) int64 {
+	var points int64 = campaign.Bonus
+	if campaign.Multiplier > 1 {
+		// Round up like the item description rule
+		points += int64(math.Ceil(float64(base) * (campaign.Multiplier - 1)))
+	}
+	if campaign.MaxBonus > 0 && points > campaign.MaxBonus {
+		points = campaign.MaxBonus
+	}
+	return points
+}
+
+// campaignStore keeps the campaigns in memory
+type campaignStore struct {
+	mutex     sync.RWMutex
+	campaigns map[string]Campaign
+	lastID    int
+}
+
+// newCampaignStore creates a store with no campaigns
+func newCampaignStore() *campaignStore {
+	return &campaignStore{campaigns: make(map[string]Campaign)}
+}
+
+// Stores the campaigns in memory
+var campaigns *campaignStore = newCampaignStore()
+
+// list returns the campaigns ordered by id
+func (s *campaignStore) list() []Campaign {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	list := make([]Campaign, 0, len(s.campaigns))
+	for _, campaign := range s.campaigns {
+		list = append(list, campaign)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		return idNumber(list[a].ID, "Campaign") < idNumber(list[b].ID, "Campaign")
+	})
+	return list
+}
+
+// bonusesWithTerms works out the campaign bonuses for the receipt, applying the stacking rules, and also
+// returns the campaigns that gave them by id
+func (s *campaignStore) bonusesWithTerms(receipt Receipt, base int64) ([]CampaignBonus, map[string]Campaign) {
+	bonuses := []CampaignBonus{}
+	terms := make(map[string]Campaign)
+	var best *CampaignBonus
+	var bestCampaign Campaign
+	for _, campaign := range s.list() {
+		if !campaign.matches(receipt) {
+			continue
+		}
+		bonus := CampaignBonus{CampaignID: campaign.ID, Name: campaign.Name, Points: campaign.bonus(base)}
+		if campaign.Stackable {
+			bonuses = append(bonuses, bonus)
+			terms[campaign.ID] = campaign
+		} else if best == nil || bonus.Points > best.Points {
+			best = &bonus
+			bestCampaign = campaign
+		}
+	}
+	// Only the non-stackable campaign that gave the most points is recorded
+	if best != nil {
+		bonuses = append(bonuses, *best)
+		terms[best.CampaignID] = bestCampaign
+	}
+	return bonuses, terms
+}
+
+// findCampaign returns the campaign if it exists and belongs to the tenant
+func findCampaign(id string, tenant string) (Campaign, bool) {
+	campaigns.mutex.RLock()
+	defer campaigns.mutex.RUnlock()
+	campaign, ok := campaigns.campaigns[id]
+	// Campaigns of other tenants are reported as not found
+	if !ok || campaign.Tenant != tenant {
+		return Campaign{}, false
+	}
+	return campaign, true
+}
+
+// bindCampaign reads and validates the campaign in the request body, writing the 400 response if it is invalid
+func bindCampaign(c *gin.Context) (Campaign, bool) {
+	var campaign Campaign
+	if err := c.ShouldBindJSON(&campaign); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusBadRequest, "The campaign is invalid.")
+		return campaign, false
+	}
+	if err := campaign.validate(); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusBadRequest, "The campaign is invalid: "+err.Error()+".")
+		return campaign, false
+	}
+	return campaign, true
+}
+
+// createCampaign adds a new campaign
+func createCampaign(c *gin.Context) {
+	campaign, ok := bindCampaign(c)
+	if !ok {
+		return
+	}
+
+	campaign.Tenant = requestTenant(c)
+	campaigns.mutex.Lock()
+	campaigns.lastID += 1
+	campaign.ID = "Campaign" + strconv.Itoa(campaigns.lastID)
+	campaigns.campaigns[campaign.ID] = campaign
+	campaigns.mutex.Unlock()
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// listCampaigns returns every campaign of the tenant of the request
+func listCampaigns(c *gin.Context) {
+	list := []Campaign{}
+	for _, campaign := range campaigns.list() {
+		if campaign.Tenant == requestTenant(c) {
+			list = append(list, campaign)
+		}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// getCampaign returns one campaign
+func getCampaign(c *gin.Context) {
+	campaign, ok := findCampaign(c.Param("id"), requestTenant(c))
+	if !ok {
+		c.String(http.StatusNotFound, "No campaign found for that ID.")
+		return
+	}
+	c.JSON(http.StatusOK, campaign)
+}
+
+// updateCampaign replaces a campaign, receipts that were already scored keep their points
+func updateCampaign(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := findCampaign(id, requestTenant(c)); !ok {
+		c.String(http.StatusNotFound, "No campaign found for that ID.")
+		return
+	}
+	campaign, ok := bindCampaign(c)
+	if !ok {
+		return
+	}
+
+	campaign.ID = id
+	campaign.Tenant = requestTenant(c)
+	campaigns.mutex.Lock()
+	campaigns.campaigns[id] = campaign
+	campaigns.mutex.Unlock()
+	c.JSON(http.StatusOK, campaign)
+}
+
+// deleteCampaign removes a campaign
+func deleteCampaign(c *gin.Context) {
+	campaigns.mutex.Lock()
+	campaign, ok := campaigns.campaigns[c.Param("id")]
+	ok = ok && campaign.Tenant == requestTenant(c)
+	if ok {
+		delete(campaigns.campaigns, c.Param("id"))
+	}
+	campaigns.mutex.Unlock()
+	if !ok {
+		c.String(http.StatusNotFound, "No campaign found for that ID.")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
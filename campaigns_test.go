@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCampaignValidate
+func TestCampaignValidate(t *testing.T) {
+	valid := Campaign{Name: "Double", Start: "2022-03-01T00:00", End: "2022-03-31T23:59", Multiplier: 2}
+	assert.NoError(t, valid.validate())
+
+	invalid := map[string]Campaign{
+		"bad start":   {Name: "x", Start: "2022-03-01", End: "2022-03-31T23:59", Bonus: 5},
+		"end first":   {Name: "x", Start: "2022-03-31T00:00", End: "2022-03-01T00:00", Bonus: 5},
+		"no reward":   {Name: "x", Start: "2022-03-01T00:00", End: "2022-03-31T23:59"},
+		"multiplier":  {Name: "x", Start: "2022-03-01T00:00", End: "2022-03-31T23:59", Multiplier: 0.5},
+		"minus bonus": {Name: "x", Start: "2022-03-01T00:00", End: "2022-03-31T23:59", Bonus: -5},
+	}
+	for name, campaign := range invalid {
+		if campaign.validate() == nil {
+			t.Fatalf("validate(%s) returned no error", name)
+		}
+	}
+}
+
+// TestCampaignMatches
+func TestCampaignMatches(t *testing.T) {
+	// validReceipt2 is from M&M Corner Market on 2022-03-20 at 14:33 with four Gatorade
+	window := Campaign{Start: "2022-03-20T14:33", End: "2022-03-20T14:33"}
+	assert.True(t, window.matches(validReceipt2))
+	assert.False(t, Campaign{Start: "2022-03-20T14:34", End: "2022-03-21T00:00"}.matches(validReceipt2))
+	assert.False(t, Campaign{Start: "2022-03-01T00:00", End: "2022-03-20T14:32"}.matches(validReceipt2))
+
+	retailer := Campaign{Start: "2022-01-01T00:00", End: "2022-12-31T23:59", Retailer: " m&m corner market "}
+	assert.True(t, retailer.matches(validReceipt2))
+	assert.False(t, retailer.matches(validReceipt1))
+
+	item := Campaign{Start: "2022-01-01T00:00", End: "2022-12-31T23:59", ItemMatch: "gatorade"}
+	assert.True(t, item.matches(validReceipt2))
+	assert.False(t, item.matches(validReceipt1))
+}
+
+// TestCampaignBonusStackingAndCaps
+func TestCampaignBonusStackingAndCaps(t *testing.T) {
+	setup()
+	year := func(campaign Campaign) Campaign {
+		campaign.Start, campaign.End = "2022-01-01T00:00", "2022-12-31T23:59"
+		return campaign
+	}
+	campaigns.campaigns["Campaign1"] = year(Campaign{ID: "Campaign1", Name: "Double", Multiplier: 2})
+	campaigns.campaigns["Campaign2"] = year(Campaign{ID: "Campaign2", Name: "Triple capped", Multiplier: 3, MaxBonus: 50})
+	campaigns.campaigns["Campaign3"] = year(Campaign{ID: "Campaign3", Name: "Gatorade", ItemMatch: "Gatorade", Bonus: 100, Stackable: true})
+
+	// Of the two multipliers only the one giving the most points applies, the item bonus stacks on top
+	bonuses, terms := campaigns.bonusesWithTerms(validReceipt2, 109)
+	assert.Equal(t, []CampaignBonus{
+		{CampaignID: "Campaign3", Name: "Gatorade", Points: 100},
+		{CampaignID: "Campaign1", Name: "Double", Points: 109},
+	}, bonuses)
+	assert.Len(t, terms, 2)
+	assert.Contains(t, terms, "Campaign1")
+	assert.Contains(t, terms, "Campaign3")
+
+	// With a small base the capped triple gives more than the double, only its terms are kept
+	bonuses, terms = campaigns.bonusesWithTerms(validReceipt1, 20)
+	assert.Equal(t, []CampaignBonus{{CampaignID: "Campaign2", Name: "Triple capped", Points: 40}}, bonuses)
+	assert.Equal(t, map[string]Campaign{"Campaign2": campaigns.campaigns["Campaign2"]}, terms)
+
+	teardown()
+}
+
+// TestCampaignEndpointsAndScoring
+func TestCampaignEndpointsAndScoring(t *testing.T) {
+	setup()
+	router := newTestRouter(t, defaultConfig())
+
+	w := serveJSON(router, "POST", "/campaigns", Campaign{Name: "Gatorade", Start: "2022-03-01T00:00", End: "2022-03-31T23:59", ItemMatch: "Gatorade", Bonus: 100})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var created Campaign
+	json.Unmarshal(w.Body.Bytes(), &created)
+	assert.Equal(t, "Campaign1", created.ID)
+
+	w = serveJSON(router, "POST", "/campaigns", Campaign{Name: "Nothing", Start: "2022-03-01T00:00", End: "2022-03-31T23:59"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The campaign is invalid: the campaign needs a multiplier or a bonus.", w.Body.String())
+
+	serveJSON(router, "POST", "/receipts/process", validReceipt2)
+	w = serveJSON(router, "GET", "/receipts/Receipt1/points", nil)
+	expectedResponse, _ := json.Marshal(PointsGeneratedResponse{Points: 209, RuleVersion: "1"})
+	assert.Equal(t, string(expectedResponse), w.Body.String())
+
+	var breakdown PointsBreakdown
+	json.Unmarshal(serveJSON(router, "GET", "/receipts/Receipt1/breakdown", nil).Body.Bytes(), &breakdown)
+	assert.Equal(t, int64(109), breakdown.Base)
+	assert.Equal(t, []CampaignBonus{{CampaignID: "Campaign1", Name: "Gatorade", Points: 100}}, breakdown.Campaigns)
+
+	// Updating the campaign does not change receipts that were already scored
+	created.Bonus = 5
+	assert.Equal(t, http.StatusOK, serveJSON(router, "PUT", "/campaigns/Campaign1", created).Code)
+	w = serveJSON(router, "GET", "/campaigns/Campaign1", nil)
+	json.Unmarshal(w.Body.Bytes(), &created)
+	assert.Equal(t, int64(5), created.Bonus)
+	w = serveJSON(router, "GET", "/receipts/Receipt1/points", nil)
+	assert.Equal(t, string(expectedResponse), w.Body.String())
+
+	var list []Campaign
+	json.Unmarshal(serveJSON(router, "GET", "/campaigns", nil).Body.Bytes(), &list)
+	assert.Len(t, list, 1)
+
+	assert.Equal(t, http.StatusNoContent, serveJSON(router, "DELETE", "/campaigns/Campaign1", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "GET", "/campaigns/Campaign1", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "DELETE", "/campaigns/Campaign1", nil).Code)
+
+	teardown()
+}
+
+// TestCampaignEndpointsNeedAdmin
+func TestCampaignEndpointsNeedAdmin(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.Auth.APIKeysFile = writeAPIKeyFile(t, map[string]string{"client-a": "key-a"})
+	router := newTestRouter(t, cfg)
+	assert.Equal(t, http.StatusForbidden, serveJSON(router, "GET", "/campaigns", nil, apiKeyHeader, "key-a").Code)
+	teardown()
+}
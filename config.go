@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Config holds the settings for the webservice, loaded from a JSON file
+type Config struct {
+	Address   string          `json:"address"`
+	Logging   LoggingConfig   `json:"logging"`
+	Auth      AuthConfig      `json:"auth"`
+	RateLimit RateLimitConfig `json:"rateLimit"`
+	Limits    LimitsConfig    `json:"limits"`
+	Expiry    ExpiryConfig    `json:"expiry"`
+	// TrustedProxies are the addresses or CIDR ranges of the proxies whose X-Forwarded-For header is used for
+	// the client IP, no proxy is trusted when empty
+	TrustedProxies []string `json:"trustedProxies"`
+	// Tiers are the loyalty tiers in ascending order of MinPoints, there are no tiers when empty
+	Tiers []TierConfig `json:"tiers"`
+	// RulesFile is the path to a JSON file with the versions of the scoring rules, only the default rules are used when empty
+	RulesFile string `json:"rulesFile"`
+	// RulesWatchSeconds is how often the rules file is checked for changes, 0 to only reload on SIGHUP or request
+	RulesWatchSeconds int `json:"rulesWatchSeconds"`
+	// Tenants controls how the tenant of a request is found and the settings of each tenant
+	Tenants TenantsConfig `json:"tenants"`
+	// TimeZones sets the time zones the purchase dates and times of the stores are read in
+	TimeZones TimeZonesConfig `json:"timeZones"`
+	// InputFormats sets the purchase date and time layouts that are accepted
+	InputFormats InputFormatsConfig `json:"inputFormats"`
+	// PurchaseWindow rejects receipts dated too far in the future or the past
+	PurchaseWindow PurchaseWindowConfig `json:"purchaseWindow"`
+	// Text sets the characters allowed in the retailer and item descriptions
+	Text TextConfig `json:"text"`
+	// Currencies sets the currencies of the receipts and the exchange rates of the round amount rules
+	Currencies CurrenciesConfig `json:"currencies"`
+}
+
+// LoggingConfig controls the structured request logging
+type LoggingConfig struct {
+	// Level is one of "debug", "info", "warn" or "error"
+	Level string `json:"level"`
+	// RedactPII hides retailer names and item descriptions in the logs
+	RedactPII bool `json:"redactPII"`
+}
+
+// AuthConfig controls how clients authenticate, authentication is off when neither keys nor tokens are configured
+type AuthConfig struct {
+	// APIKeysFile is the path to a JSON list of {"clientId", "keyHash", "scopes", "tenant"} entries
+	APIKeysFile string    `json:"apiKeysFile"`
+	JWT         JWTConfig `json:"jwt"`
+}
+
+// JWTConfig controls the validation of bearer tokens, tokens are not accepted when JWKS is empty
+type JWTConfig struct {
+	// JWKS is the path or http(s) URL of the JSON Web Key Set with the RS256/ES256 public keys
+	JWKS string `json:"jwks"`
+	// Issuer and Audience are checked against the iss and aud claims when set
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+}
+
+// RateLimitConfig assigns each client a tier of rate limits, there are no limits when no tiers are configured
+type RateLimitConfig struct {
+	// DefaultTier is used for clients that are not listed in Clients and for unauthenticated callers
+	DefaultTier string              `json:"defaultTier"`
+	Tiers       map[string]RateTier `json:"tiers"`
+	// Clients maps a client id to the name of its tier
+	Clients map[string]string `json:"clients"`
+}
+
+// RateTier holds the limits for one tier of clients
+type RateTier struct {
+	// Routes is keyed by "METHOD /path" as registered with the router, "*" applies to every other route
+	Routes map[string]RateLimit `json:"routes"`
+	// DailyReceiptQuota is the number of receipts that can be stored per day, 0 for no quota
+	DailyReceiptQuota int `json:"dailyReceiptQuota"`
+}
+
+// LimitsConfig bounds the size of the receipts that are accepted, 0 turns a limit off
+type LimitsConfig struct {
+	MaxBodyBytes int64 `json:"maxBodyBytes"`
+	MaxItems     int   `json:"maxItems"`
+	// MaxFieldLength is the most characters allowed in any string field of the receipt
+	MaxFieldLength int `json:"maxFieldLength"`
+	// DisallowUnknownFields rejects receipts with keys that are not in the schema
+	DisallowUnknownFields bool `json:"disallowUnknownFields"`
+}
+
+// ExpiryConfig sets when earned points expire, points never expire when Policy is empty
+type ExpiryConfig struct {
+	// Policy is "months" to expire points Months after the purchase date,
+	// or "endOfFollowingYear" to expire them at the end of the year after the purchase
+	Policy string `json:"policy"`
+	Months int    `json:"months"`
+	// JobIntervalMinutes is how often the expired points are written to the ledger
+	JobIntervalMinutes int `json:"jobIntervalMinutes"`
+}
+
+// TierConfig is a loyalty tier reached by earning MinPoints in the last 12 months
+type TierConfig struct {
+	Name      string `json:"name"`
+	MinPoints int64  `json:"minPoints"`
+	// The points of a receipt are multiplied by Multiplier (rounded up), then Bonus is added
+	Multiplier float64 `json:"multiplier"`
+	Bonus      int64   `json:"bonus"`
+}
+
+// The active configuration, starts with the defaults so handlers work without a config file.
+// setupRouter replaces it with the loaded config
+var appConfig Config = defaultConfig()
+
+// defaultConfig returns the settings used when no config file is given
+func defaultConfig() Config {
+	return Config{
+		Address: "localhost:8080",
+		Logging: LoggingConfig{
+			Level:     "info",
+			RedactPII: true,
+		},
+		Limits: LimitsConfig{
+			MaxBodyBytes:   1 << 20,
+			MaxItems:       500,
+			MaxFieldLength: 256,
+		},
+		Expiry: ExpiryConfig{
+			JobIntervalMinutes: 60,
+		},
+		RulesWatchSeconds: 10,
+		InputFormats: InputFormatsConfig{
+			Strict: true,
+		},
+		Text: TextConfig{
+			Policy:      textPolicyASCII,
+			Punctuation: "'’.,",
+		},
+	}
+}
+
+// loadConfig reads the JSON config file at path on top of the defaults
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Expiry.validate(); err != nil {
+		return cfg, err
+	}
+	if err := validateTiers(cfg.Tiers); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Tenants.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.TimeZones.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.InputFormats.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.PurchaseWindow.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Text.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Currencies.validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
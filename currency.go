@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The currency of receipts that do not give one, and of every receipt when no config is given
+const defaultCurrency = "USD"
+
+// CurrenciesConfig sets the currencies receipts can be in and the one the amount rules use
+type CurrenciesConfig struct {
+	// Default is the ISO 4217 code of receipts without a currency, "USD" when empty
+	Default string `json:"default"`
+	// Accepted are the other currencies receipts can be in. The default, the base and the currencies with a
+	// rate are always accepted. Without a base the rules read the amounts in the receipt's own units
+	Accepted []string `json:"accepted"`
+	// MinorUnits adds currencies to the built-in table or changes their number of decimals, like {"XAU": 0}
+	MinorUnits map[string]int `json:"minorUnits"`
+	// Base is the currency the roundDollar, multipleOfQuarter and itemDescriptionLength rules look at the
+	// amounts in. When it is empty the rules use the receipt's own currency
+	Base string `json:"base"`
+	// Rates is the value of one unit of each currency in Base, like {"EUR": 1.08}. Receipts in a currency
+	// without a rate are rejected when Base is set
+	Rates map[string]float64 `json:"rates"`
+}
+
+// The number of decimals of the ISO 4217 currencies that do not have two
+var isoMinorUnits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// The ISO 4217 currencies with two decimals
+var twoDecimalCurrencies = strings.Fields(`
+	AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BMD BND BOB BRL BSD BTN BWP BYN BZD CAD CDF CHF
+	CNY COP CRC CUP CVE CZK DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD GTQ GYD HKD HNL HTG
+	HUF IDR ILS INR IRR JMD KES KGS KHR KPW KYD KZT LAK LBP LKR LRD LSL MAD MDL MGA MKD MMK MNT MOP MRU
+	MUR MVR MWK MXN MYR MZN NAD NGN NIO NOK NPR NZD PAB PEN PGK PHP PKR PLN QAR RON RSD RUB SAR SBD SCR
+	SDG SEK SGD SHP SLE SOS SRD SSP STN SVC SYP SZL THB TJS TMT TOP TRY TTD TWD TZS UAH USD UYU UZS VES
+	WST XCD YER ZAR ZMW ZWL`)
+
+func init() {
+	for _, code := range twoDecimalCurrencies {
+		isoMinorUnits[code] = 2
+	}
+}
+
+// ISO 4217 codes are three capital letters
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// minorUnits returns the number of decimals of the currency, false when it is not known
+func (cfg CurrenciesConfig) minorUnits(currency string) (int, bool) {
+	if units, ok := cfg.MinorUnits[currency]; ok {
+		return units, true
+	}
+	units, ok := isoMinorUnits[currency]
+	return units, ok
+}
+
+// defaultCurrency returns the currency of receipts that do not give one
+func (cfg CurrenciesConfig) defaultCurrency() string {
+	if cfg.Default == "" {
+		return defaultCurrency
+	}
+	return cfg.Default
+}
+
+// validate checks every currency is known and every rate is positive
+func (cfg CurrenciesConfig) validate() error {
+	for currency, units := range cfg.MinorUnits {
+		if !currencyCodePattern.MatchString(currency) {
+			return fmt.Errorf("currency %q needs a code of three capital letters", currency)
+		}
+		if units < 0 || units > 4 {
+			return fmt.Errorf("currency %s needs between 0 and 4 decimals", currency)
+		}
+	}
+	if _, ok := cfg.minorUnits(cfg.defaultCurrency()); !ok {
+		return fmt.Errorf("unknown default currency %s", cfg.Default)
+	}
+	for _, currency := range cfg.Accepted {
+		if _, ok := cfg.minorUnits(currency); !ok {
+			return fmt.Errorf("unknown accepted currency %s", currency)
+		}
+	}
+	if _, ok := cfg.minorUnits(cfg.Base); cfg.Base != "" && !ok {
+		return fmt.Errorf("unknown base currency %s", cfg.Base)
+	}
+	if _, ok := cfg.rate(cfg.defaultCurrency()); !ok {
+		return fmt.Errorf("no exchange rate from the default currency %s to %s", cfg.defaultCurrency(), cfg.Base)
+	}
+	if cfg.Base == "" && len(cfg.Rates) > 0 {
+		return errors.New("the exchange rates need a base currency")
+	}
+	for currency, rate := range cfg.Rates {
+		if _, ok := cfg.minorUnits(currency); !ok {
+			return fmt.Errorf("unknown currency %s in the exchange rates", currency)
+		}
+		if rate <= 0 || math.IsInf(rate, 0) {
+			return fmt.Errorf("the exchange rate of %s must be more than 0", currency)
+		}
+	}
+	return nil
+}
+
+// receiptCurrency returns the currency of the receipt in capitals, the default when it gives none
+func (cfg CurrenciesConfig) receiptCurrency(receipt Receipt) (string, error) {
+	currency := strings.ToUpper(strings.TrimSpace(receipt.Currency))
+	if currency == "" {
+		return cfg.defaultCurrency(), nil
+	}
+	if _, ok := cfg.minorUnits(currency); !ok {
+		return "", fmt.Errorf("unknown currency %s", receipt.Currency)
+	}
+	if _, ok := cfg.rate(currency); !ok {
+		return "", fmt.Errorf("no exchange rate from %s to %s", currency, cfg.Base)
+	}
+	if !cfg.accepted(currency) {
+		return "", fmt.Errorf("currency %s is not accepted", currency)
+	}
+	return currency, nil
+}
+
+// accepted reports whether receipts can be in the currency
+func (cfg CurrenciesConfig) accepted(currency string) bool {
+	if _, ok := cfg.Rates[currency]; ok || currency == cfg.defaultCurrency() || currency == cfg.Base {
+		return true
+	}
+	return slices.Contains(cfg.Accepted, currency)
+}
+
+// rate returns the value of one unit of the currency in Base, there is always one when Base is not set
+func (cfg CurrenciesConfig) rate(currency string) (float64, bool) {
+	if cfg.Base == "" || currency == cfg.Base {
+		return 1, true
+	}
+	rate, ok := cfg.Rates[currency]
+	return rate, ok
+}
+
+// decimals returns the number of digits after the decimal point of an amount
+func decimals(amount string) int {
+	_, fraction, _ := strings.Cut(amount, ".")
+	return len(fraction)
+}
+
+// amountField is an amount of the receipt and its name in the errors
+type amountField struct {
+	name  string
+	value string
+}
+
+// checkAmounts checks the amounts have the number of decimals of the receipt's currency, the optional
+// amounts are only checked when they are given. Unit prices can have more decimals, like fuel prices
+func (cfg CurrenciesConfig) checkAmounts(receipt Receipt) error {
+	units, _ := cfg.minorUnits(receipt.Currency)
+	amounts := []amountField{
+		{"total", receipt.Total},
+		{"tax", receipt.Tax},
+		{"tip", receipt.Tip},
+	}
+	for i, discount := range receipt.Discounts {
+		amounts = append(amounts, amountField{fmt.Sprintf("discounts[%d].amount", i), discount.Amount})
+	}
+	for i, item := range receipt.Items {
+		if item.UnitPrice != "" && decimals(item.UnitPrice) < units {
+			return fmt.Errorf("items[%d].unitPrice needs at least %d decimals in %s", i, units, receipt.Currency)
+		}
+		amounts = append(amounts,
+			amountField{fmt.Sprintf("items[%d].price", i), item.Price},
+			amountField{fmt.Sprintf("items[%d].discount", i), item.Discount},
+			amountField{fmt.Sprintf("items[%d].tax", i), item.Tax},
+		)
+	}
+	for _, amount := range amounts {
+		if amount.value != "" && decimals(amount.value) != units {
+			return fmt.Errorf("the %s needs %d decimals in %s", amount.name, units, receipt.Currency)
+		}
+	}
+	return nil
+}
+
+// ruleTotal returns the total the round amount rules look at, converted to Base when it is set
+func (cfg CurrenciesConfig) ruleTotal(receipt Receipt) string {
+	return cfg.ruleAmount(receipt, receipt.Total)
+}
+
+// ruleItems returns the items the price rules look at, with the prices converted to Base when it is set
+func (cfg CurrenciesConfig) ruleItems(receipt Receipt) []Item {
+	if cfg.Base == "" {
+		return receipt.Items
+	}
+	items := slices.Clone(receipt.Items)
+	for i := range items {
+		items[i].Price = cfg.ruleAmount(receipt, items[i].Price)
+	}
+	return items
+}
+
+// ruleAmount converts an amount of the receipt to Base when it is set, rounded to the decimals of Base
+func (cfg CurrenciesConfig) ruleAmount(receipt Receipt, amount string) string {
+	currency := receipt.Currency
+	if currency == "" {
+		currency = cfg.defaultCurrency()
+	}
+	rate, ok := cfg.rate(currency)
+	if cfg.Base == "" || currency == cfg.Base || !ok {
+		return amount
+	}
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return amount
+	}
+	units, _ := cfg.minorUnits(cfg.Base)
+	scale := math.Pow10(units)
+	return strconv.FormatFloat(math.Round(value*rate*scale)/scale, 'f', units, 64)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// currencyReceipt returns validReceipt2 in the currency, with every price and the total replaced
+func currencyReceipt(currency string, price string, total string) Receipt {
+	receipt := validReceipt2
+	receipt.Currency = currency
+	receipt.Total = total
+	receipt.Items = make([]Item, len(validReceipt2.Items))
+	for i, item := range validReceipt2.Items {
+		receipt.Items[i] = Item{ShortDescription: item.ShortDescription, Price: price}
+	}
+	return receipt
+}
+
+// TestRoundAmountsInAnyCurrency
+func TestRoundAmountsInAnyCurrency(t *testing.T) {
+	round := map[string]int64{"1500": 50, "1.000": 50, "1.250": 0, "9.00": 50, "9.10": 0, "0.0100": 0}
+	for total, expected := range round {
+		assert.Equal(t, expected, getRoundDollarPoints(total), total)
+	}
+	quarter := map[string]int64{"1500": 25, "1.250": 25, "1.500": 25, "1.750": 25, "1.125": 0, "9.05": 0, "9.2500": 25}
+	for total, expected := range quarter {
+		assert.Equal(t, expected, getMultipleOfQuarterPoints(total), total)
+	}
+}
+
+// TestReceiptCurrency
+func TestReceiptCurrency(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.Currencies.Accepted = []string{"JPY", "BHD"}
+	router := newTestRouter(t, cfg)
+
+	// validReceipt2 scores 109 in dollars, and the same in yen and dinars with their own decimals
+	tests := []Receipt{
+		currencyReceipt("", "2.25", "9.00"),
+		currencyReceipt("jpy", "225", "900"),
+		currencyReceipt("BHD", "2.250", "9.000"),
+	}
+	for _, receipt := range tests {
+		var created ReceiptCreatedResponse
+		w := serveJSON(router, "POST", "/receipts/process", receipt)
+		assert.Equal(t, http.StatusOK, w.Code, receipt.Currency)
+		json.Unmarshal(w.Body.Bytes(), &created)
+		assert.Equal(t, int64(109), calcuatePoints(receiptsMap[created.ID]), receipt.Currency)
+	}
+	assert.Len(t, receiptsMap, len(tests))
+	currencies := []string{}
+	for _, receipt := range receiptsMap {
+		currencies = append(currencies, receipt.Currency)
+	}
+	assert.ElementsMatch(t, []string{"USD", "JPY", "BHD"}, currencies)
+
+	// Amounts with the decimals of another currency are invalid
+	invalid := []Receipt{
+		currencyReceipt("JPY", "2.25", "9.00"),
+		currencyReceipt("BHD", "2.25", "9.00"),
+		currencyReceipt("USD", "225", "900"),
+		currencyReceipt("JPY", "2.25", "900"),
+	}
+	for _, receipt := range invalid {
+		w := serveJSON(router, "POST", "/receipts/process", receipt)
+		assert.Equal(t, http.StatusBadRequest, w.Code, receipt.Currency)
+		assert.Equal(t, "The receipt is invalid.", w.Body.String(), receipt.Currency)
+	}
+
+	w := serveJSON(router, "POST", "/receipts/process", currencyReceipt("XYZ", "2.25", "9.00"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The receipt is invalid: unknown currency XYZ.", w.Body.String())
+
+	// Only the default currency is accepted without a config, as yen amounts would score many more points
+	router = newTestRouter(t, defaultConfig())
+	w = serveJSON(router, "POST", "/receipts/process", currencyReceipt("JPY", "225", "900"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The receipt is invalid: currency JPY is not accepted.", w.Body.String())
+
+	teardown()
+}
+
+// TestConvertedRoundAmounts
+func TestConvertedRoundAmounts(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.Currencies = CurrenciesConfig{Base: "USD", Rates: map[string]float64{"EUR": 1.08, "JPY": 0.01}}
+	router := newTestRouter(t, cfg)
+
+	// 9.25 EUR is 9.99 USD and 1500 JPY is 15.00 USD, the round amount and quarter points are 75
+	tests := map[string]struct {
+		receipt  Receipt
+		expected int64
+	}{
+		"EUR": {currencyReceipt("EUR", "2.25", "9.25"), 34},
+		"JPY": {currencyReceipt("JPY", "225", "1500"), 109},
+		"USD": {currencyReceipt("USD", "2.25", "9.25"), 59},
+	}
+	for name, test := range tests {
+		var score ReceiptScoreResponse
+		w := serveJSON(router, "POST", "/receipts/score", test.receipt)
+		assert.Equal(t, http.StatusOK, w.Code, name)
+		json.Unmarshal(w.Body.Bytes(), &score)
+		assert.Equal(t, test.expected, score.Points, name)
+	}
+
+	w := serveJSON(router, "POST", "/receipts/process", currencyReceipt("GBP", "2.25", "9.00"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The receipt is invalid: no exchange rate from GBP to USD.", w.Body.String())
+
+	// The item prices are converted too, 1001 JPY is 10.01 USD and its 3 character description gives 3 points
+	receipt := validReceipt1
+	receipt.Currency = "JPY"
+	receipt.Items = []Item{{ShortDescription: "Gum", Price: "1001"}}
+	receipt.Total = "1001"
+	breakdown := scoreReceiptRules(t, router, receipt)
+	assert.Contains(t, breakdown.Rules, RulePoints{Rule: "itemDescriptionLength", Points: 3})
+	assert.Contains(t, breakdown.Rules, RulePoints{Rule: "roundDollar", Points: 0})
+
+	teardown()
+}
+
+// scoreReceiptRules scores the receipt without storing it and returns the breakdown
+func scoreReceiptRules(t *testing.T, router *gin.Engine, receipt Receipt) *PointsBreakdown {
+	var score ReceiptScoreResponse
+	w := serveJSON(router, "POST", "/receipts/score", receipt)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &score)
+	return score.Breakdown
+}
+
+// TestCurrenciesConfigValidate
+func TestCurrenciesConfigValidate(t *testing.T) {
+	assert.NoError(t, CurrenciesConfig{}.validate())
+	assert.NoError(t, CurrenciesConfig{Default: "XAU", MinorUnits: map[string]int{"XAU": 0}}.validate())
+	assert.NoError(t, CurrenciesConfig{Default: "EUR", Base: "USD", Rates: map[string]float64{"EUR": 1.08}}.validate())
+
+	invalid := map[string]CurrenciesConfig{
+		"unknown default currency ABC":                          {Default: "ABC"},
+		"unknown base currency ABC":                             {Base: "ABC"},
+		"unknown accepted currency ABC":                         {Accepted: []string{"ABC"}},
+		"the exchange rates need a base currency":               {Rates: map[string]float64{"EUR": 1.08}},
+		"no exchange rate from the default currency EUR to USD": {Default: "EUR", Base: "USD"},
+		"unknown currency ABC in the exchange rates":            {Base: "USD", Rates: map[string]float64{"ABC": 1}},
+		"the exchange rate of EUR must be more than 0":          {Base: "USD", Rates: map[string]float64{"EUR": 0}},
+		`currency "xau" needs a code of three capital letters`:  {MinorUnits: map[string]int{"xau": 0}},
+		"currency XAU needs between 0 and 4 decimals":           {MinorUnits: map[string]int{"XAU": 5}},
+	}
+	for expected, cfg := range invalid {
+		assert.EqualError(t, cfg.validate(), expected)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Customer collects the points of the receipts submitted against it
+type Customer struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Owner is the client that created the customer, see ownerKey
+	Owner string `json:"-"`
+	// Tenant is the tenant of the client
+	Tenant string `json:"-"`
+}
+
+type CreateCustomerRequest struct {
+	Name string `json:"name" binding:"required,max=256"`
+}
+
+type CustomerCreatedResponse struct {
+	ID string `json:"id"`
+}
+
+type BalanceResponse struct {
+	CustomerID string `json:"customerId"`
+	Balance    int64  `json:"balance"`
+	// Held is the part of the points already taken out of the balance for open redemptions
+	Held int64 `json:"held"`
+}
+
+type LedgerResponse struct {
+	CustomerID string        `json:"customerId"`
+	Entries    []LedgerEntry `json:"entries"`
+}
+
+// Stores the customers in memory
+var customersMap map[string]Customer = make(map[string]Customer)
+
+// Used to create unique string for customerId
+var customerNum int = 0
+
+// Guards customersMap and customerNum
+var customersMutex sync.RWMutex
+
+// findCustomer returns the customer if it exists and belongs to the owner, see ownerKey
+func findCustomer(customerID string, owner string) (Customer, bool) {
+	customersMutex.RLock()
+	defer customersMutex.RUnlock()
+
+	customer, ok := customersMap[customerID]
+	if !ok || customer.Owner != owner {
+		return Customer{}, false
+	}
+	return customer, true
+}
+
+// createCustomer validates the JSON body and adds a new customer for the client
+func createCustomer(c *gin.Context) {
+	var request CreateCustomerRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusBadRequest, "The customer is invalid.")
+		return
+	}
+
+	customersMutex.Lock()
+	customerNum += 1
+	customer := Customer{
+		ID:        "Customer" + strconv.Itoa(customerNum),
+		Name:      request.Name,
+		CreatedAt: time.Now().UTC(),
+		Owner:     requestOwner(c),
+		Tenant:    requestTenant(c),
+	}
+	customersMap[customer.ID] = customer
+	customersMutex.Unlock()
+
+	// Place the customer in the entry tier
+	tiers.update(customer.ID)
+
+	c.JSON(http.StatusOK, CustomerCreatedResponse{ID: customer.ID})
+}
+
+// getBalance returns the customer's points balance
+func getBalance(c *gin.Context) {
+	customer, ok := findCustomer(c.Param("id"), requestOwner(c))
+	if !ok {
+		c.String(http.StatusNotFound, "No customer found for that ID.")
+		return
+	}
+	c.JSON(http.StatusOK, BalanceResponse{
+		CustomerID: customer.ID,
+		Balance:    ledger.balance(customer.ID),
+		Held:       ledger.heldPoints(customer.ID),
+	})
+}
+
+// getLedger returns every ledger entry for the customer, oldest first
+func getLedger(c *gin.Context) {
+	customer, ok := findCustomer(c.Param("id"), requestOwner(c))
+	if !ok {
+		c.String(http.StatusNotFound, "No customer found for that ID.")
+		return
+	}
+	c.JSON(http.StatusOK, LedgerResponse{CustomerID: customer.ID, Entries: ledger.entriesFor(customer.ID)})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCustomerEarnsPointsForReceipts
+func TestCustomerEarnsPointsForReceipts(t *testing.T) {
+	setup()
+	router := newTestRouter(t, defaultConfig())
+
+	w := serveJSON(router, "POST", "/customers", CreateCustomerRequest{Name: "Ada"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var created CustomerCreatedResponse
+	json.Unmarshal(w.Body.Bytes(), &created)
+	assert.Equal(t, "Customer1", created.ID)
+
+	receipt1 := validReceipt1
+	receipt1.CustomerID = created.ID
+	receipt2 := validReceipt2
+	receipt2.CustomerID = created.ID
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/receipts/process", receipt1).Code)
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/receipts/process", receipt2).Code)
+	// Receipts without a customer do not earn anything
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/receipts/process", validReceipt3).Code)
+
+	w = serveJSON(router, "GET", "/customers/Customer1/balance", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	expectedBalance, _ := json.Marshal(BalanceResponse{CustomerID: "Customer1", Balance: 28 + 109})
+	assert.Equal(t, string(expectedBalance), w.Body.String())
+
+	w = serveJSON(router, "GET", "/customers/Customer1/ledger", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var ledgerResponse LedgerResponse
+	json.Unmarshal(w.Body.Bytes(), &ledgerResponse)
+	assert.Len(t, ledgerResponse.Entries, 2)
+	assert.Equal(t, entryEarn, ledgerResponse.Entries[0].Type)
+	assert.Equal(t, int64(28), ledgerResponse.Entries[0].Points)
+	assert.Equal(t, "Receipt1", ledgerResponse.Entries[0].ReceiptID)
+	assert.Equal(t, "Receipt2", ledgerResponse.Entries[1].ReceiptID)
+
+	teardown()
+}
+
+// TestCustomerUnknown
+func TestCustomerUnknown(t *testing.T) {
+	setup()
+	router := newTestRouter(t, defaultConfig())
+
+	receipt := validReceipt1
+	receipt.CustomerID = "Customer9"
+	w := serveJSON(router, "POST", "/receipts/process", receipt)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The receipt is invalid: unknown customer Customer9.", w.Body.String())
+	assert.Empty(t, receiptsMap)
+
+	w = serveJSON(router, "GET", "/customers/Customer9/balance", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "No customer found for that ID.", w.Body.String())
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "GET", "/customers/Customer9/ledger", nil).Code)
+
+	teardown()
+}
+
+// TestCustomerInvalid
+func TestCustomerInvalid(t *testing.T) {
+	setup()
+	router := newTestRouter(t, defaultConfig())
+	w := serveJSON(router, "POST", "/customers", map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The customer is invalid.", w.Body.String())
+	teardown()
+}
+
+// TestCustomerScopedToClient
+func TestCustomerScopedToClient(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.Auth.APIKeysFile = writeAPIKeyFile(t, map[string]string{"client-a": "key-a", "client-b": "key-b"})
+	router := newTestRouter(t, cfg)
+
+	serveJSON(router, "POST", "/customers", CreateCustomerRequest{Name: "Ada"}, apiKeyHeader, "key-a")
+
+	// Client b can neither read the customer nor submit receipts against it
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "GET", "/customers/Customer1/balance", nil, apiKeyHeader, "key-b").Code)
+	receipt := validReceipt1
+	receipt.CustomerID = "Customer1"
+	assert.Equal(t, http.StatusBadRequest, serveJSON(router, "POST", "/receipts/process", receipt, apiKeyHeader, "key-b").Code)
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/receipts/process", receipt, apiKeyHeader, "key-a").Code)
+	assert.Equal(t, int64(28), ledger.balance("Customer1"))
+
+	teardown()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// requestError is a rejected request body with the status and reason given to the client
+type requestError struct {
+	Status int
+	Reason string
+}
+
+func (e *requestError) Error() string {
+	return e.Reason
+}
+
+// invalidReceipt creates a 400 requestError with the reason
+func invalidReceipt(format string, args ...any) *requestError {
+	return &requestError{Status: http.StatusBadRequest, Reason: fmt.Sprintf(format, args...)}
+}
+
+// bindReceipt reads the request body into receipt, enforcing the size limits, rejecting duplicate
+// and (when configured) unknown keys, and running the binding checks on the result
+func bindReceipt(c *gin.Context, limits LimitsConfig, receipt *Receipt) error {
+	if c.Request.Body == nil {
+		return invalidReceipt("the request body is empty")
+	}
+	body := io.Reader(c.Request.Body)
+	if limits.MaxBodyBytes > 0 {
+		body = http.MaxBytesReader(c.Writer, c.Request.Body, limits.MaxBodyBytes)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return &requestError{
+				Status: http.StatusRequestEntityTooLarge,
+				Reason: fmt.Sprintf("the request body is larger than %d bytes", limits.MaxBodyBytes),
+			}
+		}
+		return err
+	}
+
+	if err := checkDuplicateKeys(data); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if limits.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(receipt); err != nil {
+		return invalidReceipt("%s", err.Error())
+	}
+	if decoder.More() {
+		return invalidReceipt("the request body has data after the receipt")
+	}
+
+	if err := checkReceiptLimits(receipt, limits); err != nil {
+		return err
+	}
+	// Run the binding:"required" checks that c.BindJSON would have done
+	return binding.Validator.ValidateStruct(receipt)
+}
+
+// checkReceiptLimits rejects receipts with too many items or fields that are too long
+func checkReceiptLimits(receipt *Receipt, limits LimitsConfig) error {
+	if limits.MaxItems > 0 && len(receipt.Items) > limits.MaxItems {
+		return invalidReceipt("the receipt has %d items, at most %d are allowed", len(receipt.Items), limits.MaxItems)
+	}
+	if limits.MaxFieldLength <= 0 {
+		return nil
+	}
+	fields := []struct{ name, value string }{
+		{"retailer", receipt.Retailer},
+		{"purchaseDate", receipt.PurchaseDate},
+		{"purchaseTime", receipt.PurchaseTime},
+		{"total", receipt.Total},
+		{"currency", receipt.Currency},
+		{"tax", receipt.Tax},
+		{"tip", receipt.Tip},
+	}
+	for i, item := range receipt.Items {
+		fields = append(fields, []struct{ name, value string }{
+			{fmt.Sprintf("items[%d].shortDescription", i), item.ShortDescription},
+			{fmt.Sprintf("items[%d].price", i), item.Price},
+			{fmt.Sprintf("items[%d].quantity", i), item.Quantity},
+			{fmt.Sprintf("items[%d].unitPrice", i), item.UnitPrice},
+			{fmt.Sprintf("items[%d].sku", i), item.SKU},
+			{fmt.Sprintf("items[%d].upc", i), item.UPC},
+			{fmt.Sprintf("items[%d].discount", i), item.Discount},
+			{fmt.Sprintf("items[%d].tax", i), item.Tax},
+		}...)
+	}
+	for i, discount := range receipt.Discounts {
+		fields = append(fields, []struct{ name, value string }{
+			{fmt.Sprintf("discounts[%d].description", i), discount.Description},
+			{fmt.Sprintf("discounts[%d].amount", i), discount.Amount},
+		}...)
+	}
+	for _, field := range fields {
+		if utf8.RuneCountInString(field.value) > limits.MaxFieldLength {
+			return invalidReceipt("%s is longer than %d characters", field.name, limits.MaxFieldLength)
+		}
+	}
+	return nil
+}
+
+// How deeply objects and arrays can be nested in a request body
+const maxJSONDepth = 32
+
+// checkDuplicateKeys walks the JSON document and rejects objects that repeat a key,
+// encoding/json would otherwise silently keep the last value
+func checkDuplicateKeys(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return checkDuplicateKeysValue(decoder, "", 0)
+}
+
+// checkDuplicateKeysValue reads one value from the decoder, checking any objects inside it
+func checkDuplicateKeysValue(decoder *json.Decoder, path string, depth int) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return invalidReceipt("%s", err.Error())
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return nil
+	}
+	if depth >= maxJSONDepth {
+		return invalidReceipt("the request body is nested more than %d levels deep", maxJSONDepth)
+	}
+	switch delim {
+	case '{':
+		seen := make(map[string]bool)
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return invalidReceipt("%s", err.Error())
+			}
+			key := token.(string)
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			// encoding/json matches keys to fields ignoring case, so "Total" repeats "total"
+			if seen[strings.ToLower(key)] {
+				return invalidReceipt("duplicate key %s", keyPath)
+			}
+			seen[strings.ToLower(key)] = true
+			if err := checkDuplicateKeysValue(decoder, keyPath, depth+1); err != nil {
+				return err
+			}
+		}
+	case '[':
+		for i := 0; decoder.More(); i++ {
+			if err := checkDuplicateKeysValue(decoder, fmt.Sprintf("%s[%d]", path, i), depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	// Read the closing delimiter
+	if _, err := decoder.Token(); err != nil {
+		return invalidReceipt("%s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// processRawReceipt posts the raw body to processReceipt
+func processRawReceipt(body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	processReceipt(c)
+	return w
+}
+
+// A valid receipt body with one item, %s is replaced with extra keys
+const rawReceiptFormat = `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+	"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}], "total": "6.49"%s}`
+
+// TestBindReceiptValid
+func TestBindReceiptValid(t *testing.T) {
+	setup()
+	w := processRawReceipt(fmt.Sprintf(rawReceiptFormat, ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	teardown()
+}
+
+// TestBindReceiptBodyTooLarge
+func TestBindReceiptBodyTooLarge(t *testing.T) {
+	setup()
+	appConfig.Limits.MaxBodyBytes = 64
+	w := processRawReceipt(fmt.Sprintf(rawReceiptFormat, ""))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, "The receipt is invalid: the request body is larger than 64 bytes.", w.Body.String())
+	teardown()
+}
+
+// TestBindReceiptTooManyItems
+func TestBindReceiptTooManyItems(t *testing.T) {
+	setup()
+	appConfig.Limits.MaxItems = 2
+	items := strings.Repeat(`{"shortDescription": "Gatorade", "price": "2.25"},`, 3)
+	body := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [` +
+		strings.TrimSuffix(items, ",") + `], "total": "6.75"}`
+	w := processRawReceipt(body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The receipt is invalid: the receipt has 3 items, at most 2 are allowed.", w.Body.String())
+	teardown()
+}
+
+// TestBindReceiptFieldTooLong
+func TestBindReceiptFieldTooLong(t *testing.T) {
+	setup()
+	appConfig.Limits.MaxFieldLength = 10
+	w := processRawReceipt(fmt.Sprintf(rawReceiptFormat, ""))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The receipt is invalid: items[0].shortDescription is longer than 10 characters.", w.Body.String())
+	teardown()
+}
+
+// TestBindReceiptUnknownField
+func TestBindReceiptUnknownField(t *testing.T) {
+	setup()
+	// Unknown fields are ignored by default, like before the check was added
+	body := fmt.Sprintf(rawReceiptFormat, `, "cashier": "Bob"`)
+	w := processRawReceipt(body)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	appConfig.Limits.DisallowUnknownFields = true
+	w = processRawReceipt(body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `unknown field "cashier"`)
+	teardown()
+}
+
+// TestBindReceiptDuplicateKey
+func TestBindReceiptDuplicateKey(t *testing.T) {
+	setup()
+	w := processRawReceipt(fmt.Sprintf(rawReceiptFormat, `, "total": "0.00"`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The receipt is invalid: duplicate key total.", w.Body.String())
+
+	body := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+		"items": [{"shortDescription": "Gatorade", "price": "2.25", "price": "0.25"}], "total": "2.25"}`
+	w = processRawReceipt(body)
+	assert.Equal(t, "The receipt is invalid: duplicate key items[0].price.", w.Body.String())
+
+	// Keys that only differ in case set the same field
+	w = processRawReceipt(fmt.Sprintf(rawReceiptFormat, `, "Total": "0.00"`))
+	assert.Equal(t, "The receipt is invalid: duplicate key Total.", w.Body.String())
+	teardown()
+}
+
+// TestBindReceiptTrailingData
+func TestBindReceiptTrailingData(t *testing.T) {
+	setup()
+	body := fmt.Sprintf(rawReceiptFormat, "") + fmt.Sprintf(rawReceiptFormat, "")
+	w := processRawReceipt(body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, receiptsMap)
+	teardown()
+}
+
+// TestCheckDuplicateKeysDepth
+func TestCheckDuplicateKeysDepth(t *testing.T) {
+	body := strings.Repeat("[", 40) + strings.Repeat("]", 40)
+	assert.Error(t, checkDuplicateKeys([]byte(body)))
+	assert.NoError(t, checkDuplicateKeys([]byte(`{"a": [{"b": 1}, {"b": 2}]}`)))
+}
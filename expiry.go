@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Expiry policies
+const (
+	expiryMonths             = "months"
+	expiryEndOfFollowingYear = "endOfFollowingYear"
+)
+
+// validate checks the policy name and its parameters
+func (cfg ExpiryConfig) validate() error {
+	if cfg.Policy != "" && cfg.JobIntervalMinutes <= 0 {
+		return errors.New("the expiry job needs a positive interval")
+	}
+	switch cfg.Policy {
+	case "", expiryEndOfFollowingYear:
+		return nil
+	case expiryMonths:
+		if cfg.Months <= 0 {
+			return errors.New("the months expiry policy needs a positive number of months")
+		}
+		return nil
+	default:
+		return errors.New("unknown expiry policy " + cfg.Policy)
+	}
+}
+
+// expiresAt returns when points earned on the purchase date expire, nil if they never do
+func (cfg ExpiryConfig) expiresAt(purchaseDate string) *time.Time {
+	// Already checked for valid date with validator
+	date, err := time.Parse("2006-01-02", purchaseDate)
+	if err != nil {
+		return nil
+	}
+	var expires time.Time
+	switch cfg.Policy {
+	case expiryMonths:
+		expires = date.AddDate(0, cfg.Months, 0)
+	case expiryEndOfFollowingYear:
+		// The first moment of the year after the following year
+		expires = time.Date(date.Year()+2, time.January, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return nil
+	}
+	return &expires
+}
+
+// pointsLot is what is left of the points of one earn entry
+type pointsLot struct {
+	EntryID   string     `json:"entryId"`
+	ReceiptID string     `json:"receiptId"`
+	Points    int64      `json:"points"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	order     int
+}
+
+// lotUse is how many points a hold took from a lot, so a release can put them back
+type lotUse struct {
+	lot    *pointsLot
+	points int64
+}
+
+// lotsLocked replays the customer's entries to find what is left of each earn entry.
+// Spending takes the points that expire first (first in, first out), a reversal takes the
+// points of its own receipt first, and a release puts the points back where the hold took them
+func (l *pointsLedger) lotsLocked(customerID string) []*pointsLot {
+	var lots []*pointsLot
+	byEntry := make(map[string]*pointsLot)
+	holdUses := make(map[string][]lotUse)
+
+	for _, i := range l.byCustomer[customerID] {
+		entry := l.entries[i]
+		kind := entry.Type
+		// An adjustment that adds points is a new lot like an earn, one that takes points away is like a reversal
+		if kind == entryAdjustment {
+			kind = entryReversal
+			if entry.Points > 0 {
+				kind = entryEarn
+			}
+		}
+		switch kind {
+		case entryEarn:
+			lot := &pointsLot{EntryID: entry.ID, ReceiptID: entry.ReceiptID, Points: entry.Points, ExpiresAt: entry.ExpiresAt, order: len(lots)}
+			lots = append(lots, lot)
+			byEntry[entry.ID] = lot
+		case entryReversal:
+			remaining := -entry.Points
+			for _, lot := range lots {
+				if lot.ReceiptID == entry.ReceiptID && remaining > 0 {
+					taken := min(lot.Points, remaining)
+					lot.Points -= taken
+					remaining -= taken
+				}
+			}
+			consumeLots(lots, remaining)
+		case entryHold:
+			holdUses[entry.HoldID] = consumeLots(lots, -entry.Points)
+		case entryRelease:
+			for _, use := range holdUses[entry.HoldID] {
+				use.lot.Points += use.points
+			}
+		case entryExpire:
+			if lot, ok := byEntry[entry.SourceEntryID]; ok {
+				lot.Points += entry.Points
+			}
+		}
+	}
+	return lots
+}
+
+// consumeLots takes points from the lots that expire first and returns what was taken from each
+func consumeLots(lots []*pointsLot, points int64) []lotUse {
+	ordered := make([]*pointsLot, len(lots))
+	copy(ordered, lots)
+	sort.SliceStable(ordered, func(a, b int) bool {
+		return expiresBefore(ordered[a], ordered[b])
+	})
+
+	var uses []lotUse
+	for _, lot := range ordered {
+		if points <= 0 {
+			break
+		}
+		if lot.Points <= 0 {
+			continue
+		}
+		taken := min(lot.Points, points)
+		lot.Points -= taken
+		points -= taken
+		uses = append(uses, lotUse{lot: lot, points: taken})
+	}
+	return uses
+}
+
+// expiresBefore orders lots by expiry, then by when they were earned, lots that never expire go last
+func expiresBefore(a *pointsLot, b *pointsLot) bool {
+	switch {
+	case a.ExpiresAt == nil && b.ExpiresAt == nil:
+		return a.order < b.order
+	case a.ExpiresAt == nil:
+		return false
+	case b.ExpiresAt == nil:
+		return true
+	case a.ExpiresAt.Equal(*b.ExpiresAt):
+		return a.order < b.order
+	default:
+		return a.ExpiresAt.Before(*b.ExpiresAt)
+	}
+}
+
+// expiringLots returns the customer's unspent points that expire before the given time, soonest first
+func (l *pointsLedger) expiringLots(customerID string, before time.Time) []pointsLot {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	lots := l.lotsLocked(customerID)
+	sort.SliceStable(lots, func(a, b int) bool {
+		return expiresBefore(lots[a], lots[b])
+	})
+	expiring := []pointsLot{}
+	for _, lot := range lots {
+		if lot.Points > 0 && lot.ExpiresAt != nil && lot.ExpiresAt.Before(before) {
+			expiring = append(expiring, *lot)
+		}
+	}
+	return expiring
+}
+
+// expirePoints writes an expire entry for every lot with points left that has expired by now, and returns how many were written
+func (l *pointsLedger) expirePoints(now time.Time) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	written := 0
+	for customerID := range l.byCustomer {
+		for _, lot := range l.lotsLocked(customerID) {
+			if lot.Points > 0 && lot.ExpiresAt != nil && !now.Before(*lot.ExpiresAt) {
+				l.appendLocked(LedgerEntry{
+					CustomerID:    customerID,
+					Type:          entryExpire,
+					Points:        -lot.Points,
+					ReceiptID:     lot.ReceiptID,
+					SourceEntryID: lot.EntryID,
+				})
+				written += 1
+			}
+		}
+	}
+	return written
+}
+
+// runExpiryJob expires points every interval until the context is cancelled
+func runExpiryJob(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if written := ledger.expirePoints(now); written > 0 {
+				logger.Info("expired points", slog.Int("entries", written))
+			}
+		}
+	}
+}
+
+type ExpiringPointsResponse struct {
+	CustomerID string      `json:"customerId"`
+	Points     int64       `json:"points"`
+	Lots       []pointsLot `json:"lots"`
+}
+
+// getExpiringPoints lists the customer's points that expire within the next ?days= days, 30 by default
+func getExpiringPoints(c *gin.Context) {
+	customer, ok := findCustomer(c.Param("id"), requestOwner(c))
+	if !ok {
+		c.String(http.StatusNotFound, "No customer found for that ID.")
+		return
+	}
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 0 {
+		c.String(http.StatusBadRequest, "The days parameter is invalid.")
+		return
+	}
+
+	lots := ledger.expiringLots(customer.ID, ledger.now().AddDate(0, 0, days))
+	response := ExpiringPointsResponse{CustomerID: customer.ID, Lots: lots}
+	for _, lot := range lots {
+		response.Points += lot.Points
+	}
+	c.JSON(http.StatusOK, response)
+}
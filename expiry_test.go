@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExpiresAtPolicies
+func TestExpiresAtPolicies(t *testing.T) {
+	months := ExpiryConfig{Policy: expiryMonths, Months: 12}
+	assert.Equal(t, time.Date(2023, 3, 20, 0, 0, 0, 0, time.UTC), *months.expiresAt("2022-03-20"))
+
+	endOfYear := ExpiryConfig{Policy: expiryEndOfFollowingYear}
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), *endOfYear.expiresAt("2022-03-20"))
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), *endOfYear.expiresAt("2022-12-31"))
+
+	assert.Nil(t, ExpiryConfig{}.expiresAt("2022-03-20"))
+}
+
+// TestExpiryConfigValidate
+func TestExpiryConfigValidate(t *testing.T) {
+	assert.NoError(t, ExpiryConfig{}.validate())
+	assert.NoError(t, ExpiryConfig{Policy: expiryMonths, Months: 6, JobIntervalMinutes: 60}.validate())
+	assert.Error(t, ExpiryConfig{Policy: expiryMonths, JobIntervalMinutes: 60}.validate())
+	assert.Error(t, ExpiryConfig{Policy: "weekly", JobIntervalMinutes: 60}.validate())
+	assert.Error(t, ExpiryConfig{Policy: expiryEndOfFollowingYear}.validate())
+}
+
+// earnAt adds an earn entry for the customer that expires at the given date
+func earnAt(l *pointsLedger, receiptID string, points int64, expires time.Time) {
+	l.append(LedgerEntry{CustomerID: "Customer1", Type: entryEarn, Points: points, ReceiptID: receiptID, ExpiresAt: &expires})
+}
+
+// TestExpirePointsSpendsOldestFirst
+func TestExpirePointsSpendsOldestFirst(t *testing.T) {
+	l := newPointsLedger()
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Receipt2 was submitted last but expires first
+	earnAt(l, "Receipt1", 100, jan.AddDate(0, 6, 0))
+	earnAt(l, "Receipt2", 50, jan.AddDate(0, 3, 0))
+
+	// The redemption spends all of Receipt2 and 30 points of Receipt1
+	redemption, err := l.hold("Customer1", 80, "", "")
+	assert.NoError(t, err)
+	l.capture(redemption.ID, "")
+
+	assert.Equal(t, 0, l.expirePoints(jan.AddDate(0, 3, 0)))
+	assert.Equal(t, 1, l.expirePoints(jan.AddDate(0, 6, 0)))
+	entries := l.entriesFor("Customer1")
+	last := entries[len(entries)-1]
+	assert.Equal(t, entryExpire, last.Type)
+	assert.Equal(t, int64(-70), last.Points)
+	assert.Equal(t, "Receipt1", last.ReceiptID)
+	assert.Equal(t, entries[0].ID, last.SourceEntryID)
+	assert.Equal(t, int64(0), l.balance("Customer1"))
+
+	// Running the job again does not expire the points twice
+	assert.Equal(t, 0, l.expirePoints(jan.AddDate(1, 0, 0)))
+}
+
+// TestExpirePointsReleasedHoldReturnsToLots
+func TestExpirePointsReleasedHoldReturnsToLots(t *testing.T) {
+	l := newPointsLedger()
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	earnAt(l, "Receipt1", 40, jan)
+	earnAt(l, "Receipt2", 60, jan.AddDate(1, 0, 0))
+
+	redemption, _ := l.hold("Customer1", 50, "", "")
+	l.release(redemption.ID, "")
+	l.reverseReceipt("Customer1", "Receipt2")
+
+	assert.Equal(t, 1, l.expirePoints(jan))
+	assert.Equal(t, int64(0), l.balance("Customer1"))
+
+	// A receipt deleted after its points expired has nothing left to reverse
+	l.reverseReceipt("Customer1", "Receipt1")
+	assert.Equal(t, int64(0), l.balance("Customer1"))
+}
+
+// TestExpirePointsAdjustments
+func TestExpirePointsAdjustments(t *testing.T) {
+	l := newPointsLedger()
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	earnAt(l, "Receipt1", 40, jan)
+	earnAt(l, "Receipt2", 60, jan.AddDate(1, 0, 0))
+
+	// Extra points are a new lot, points taken away come from the receipt's own lot first
+	later := jan.AddDate(0, 6, 0)
+	l.append(LedgerEntry{CustomerID: "Customer1", Type: entryAdjustment, Points: 10, ReceiptID: "Receipt1", ExpiresAt: &later})
+	l.append(LedgerEntry{CustomerID: "Customer1", Type: entryAdjustment, Points: -15, ReceiptID: "Receipt2"})
+
+	assert.Equal(t, 1, l.expirePoints(jan))
+	assert.Equal(t, int64(55), l.balance("Customer1"))
+	assert.Equal(t, 1, l.expirePoints(later))
+	assert.Equal(t, int64(45), l.balance("Customer1"))
+}
+
+// TestGetExpiringPoints
+func TestGetExpiringPoints(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.Expiry = ExpiryConfig{Policy: expiryMonths, Months: 12, JobIntervalMinutes: 60}
+	router := newTestRouter(t, cfg)
+	ledger.now = func() time.Time { return time.Date(2022, 12, 15, 0, 0, 0, 0, time.UTC) }
+
+	serveJSON(router, "POST", "/customers", CreateCustomerRequest{Name: "Ada"})
+	receipt1 := validReceipt1 // 2022-01-01, 28 points
+	receipt1.CustomerID = "Customer1"
+	receipt2 := validReceipt2 // 2022-03-20, 109 points
+	receipt2.CustomerID = "Customer1"
+	serveJSON(router, "POST", "/receipts/process", receipt1)
+	serveJSON(router, "POST", "/receipts/process", receipt2)
+
+	w := serveJSON(router, "GET", "/customers/Customer1/expiring?days=30", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response ExpiringPointsResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, int64(28), response.Points)
+	assert.Len(t, response.Lots, 1)
+	assert.Equal(t, "Receipt1", response.Lots[0].ReceiptID)
+
+	json.Unmarshal(serveJSON(router, "GET", "/customers/Customer1/expiring?days=365", nil).Body.Bytes(), &response)
+	assert.Equal(t, int64(137), response.Points)
+
+	assert.Equal(t, http.StatusBadRequest, serveJSON(router, "GET", "/customers/Customer1/expiring?days=soon", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "GET", "/customers/Customer2/expiring", nil).Code)
+
+	teardown()
+}
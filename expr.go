@@ -0,0 +1,717 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits of the custom rule expressions
+const (
+	maxExpressionLength = 1000
+	maxExpressionDepth  = 32
+	// An evaluation that takes more steps or more time than this gives no points
+	maxExpressionSteps = 100000
+	expressionTimeout  = 10 * time.Millisecond
+	// The points of a custom rule are clamped to plus or minus this
+	maxCustomRulePoints = 1000000
+)
+
+// CustomRule gives points to the receipts that match an expression like
+// `retailer contains "Target" && len(items) >= 5 => 15`
+type CustomRule struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+}
+
+// exprType is the static type of an expression, expressions are type checked when they are compiled
+type exprType int
+
+const (
+	typeBool exprType = iota
+	typeNumber
+	typeString
+	typeItems
+)
+
+func (t exprType) String() string {
+	return [...]string{"bool", "number", "string", "items"}[t]
+}
+
+// exprError stops an evaluation that is over the limits or divides by zero
+type exprError struct {
+	message string
+}
+
+func (e exprError) Error() string {
+	return e.message
+}
+
+// exprReceipt is the receipt with its values parsed, as seen by the expressions
+type exprReceipt struct {
+	retailer  string
+	total     float64
+	items     []exprItem
+	purchased time.Time
+}
+
+type exprItem struct {
+	description string
+	price       float64
+	quantity    float64
+	sku         string
+}
+
+// newExprReceipt parses the money, date and time values of a validated receipt
+func newExprReceipt(receipt Receipt) *exprReceipt {
+	data := &exprReceipt{retailer: receipt.Retailer}
+	data.total, _ = strconv.ParseFloat(receipt.Total, 64)
+	purchaseDate, purchaseTime := receipt.localPurchase()
+	data.purchased, _ = time.Parse(purchaseLayout, purchaseDate+" "+purchaseTime)
+	for _, item := range receipt.Items {
+		price, _ := strconv.ParseFloat(item.Price, 64)
+		quantity, _ := itemQuantity(item).Float64()
+		data.items = append(data.items, exprItem{description: item.ShortDescription, price: price, quantity: quantity, sku: item.SKU})
+	}
+	return data
+}
+
+// exprEnv is the state of one evaluation
+type exprEnv struct {
+	receipt  *exprReceipt
+	item     *exprItem
+	steps    int
+	timeout  time.Duration
+	deadline time.Time
+}
+
+// step counts one step of the evaluation and stops it once it is over the limits
+func (env *exprEnv) step() {
+	env.steps += 1
+	if env.steps > maxExpressionSteps {
+		panic(exprError{"the expression took more than " + strconv.Itoa(maxExpressionSteps) + " steps"})
+	}
+	if env.steps%256 == 0 && time.Now().After(env.deadline) {
+		panic(exprError{"the expression took longer than " + env.timeout.String()})
+	}
+}
+
+// exprNode is a compiled expression, eval returns a bool, float64, string or []exprItem depending on typ
+type exprNode struct {
+	typ  exprType
+	eval func(env *exprEnv) any
+}
+
+// The receipt values the expressions can use
+var exprVariables = map[string]exprNode{
+	"retailer": {typeString, func(env *exprEnv) any { return env.receipt.retailer }},
+	"total":    {typeNumber, func(env *exprEnv) any { return env.receipt.total }},
+	"items":    {typeItems, func(env *exprEnv) any { return env.receipt.items }},
+	"date":     {typeString, func(env *exprEnv) any { return env.receipt.purchased.Format("2006-01-02") }},
+	"time":     {typeString, func(env *exprEnv) any { return env.receipt.purchased.Format("15:04") }},
+	"year":     {typeNumber, func(env *exprEnv) any { return float64(env.receipt.purchased.Year()) }},
+	"month":    {typeNumber, func(env *exprEnv) any { return float64(env.receipt.purchased.Month()) }},
+	"day":      {typeNumber, func(env *exprEnv) any { return float64(env.receipt.purchased.Day()) }},
+	"weekday":  {typeString, func(env *exprEnv) any { return env.receipt.purchased.Weekday().String() }},
+	"hour":     {typeNumber, func(env *exprEnv) any { return float64(env.receipt.purchased.Hour()) }},
+	"minute":   {typeNumber, func(env *exprEnv) any { return float64(env.receipt.purchased.Minute()) }},
+}
+
+// The item values the expressions inside count, any, all and sum can use
+var exprItemFields = map[string]exprNode{
+	"description": {typeString, func(env *exprEnv) any { return env.item.description }},
+	"price":       {typeNumber, func(env *exprEnv) any { return env.item.price }},
+	"quantity":    {typeNumber, func(env *exprEnv) any { return env.item.quantity }},
+	"sku":         {typeString, func(env *exprEnv) any { return env.item.sku }},
+}
+
+// customProgram is a compiled custom rule
+type customProgram struct {
+	name      string
+	condition exprNode
+	points    exprNode
+}
+
+// compileCustomRule parses and type checks the rule, the expression is "condition => points"
+func compileCustomRule(rule CustomRule) (*customProgram, error) {
+	if len(rule.Expression) > maxExpressionLength {
+		return nil, fmt.Errorf("custom rule %s is longer than %d characters", rule.Name, maxExpressionLength)
+	}
+	tokens, err := lexExpression(rule.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("custom rule %s: %w", rule.Name, err)
+	}
+	parser := &exprParser{tokens: tokens}
+	program, err := parser.parseRule()
+	if err != nil {
+		return nil, fmt.Errorf("custom rule %s: %w", rule.Name, err)
+	}
+	program.name = rule.Name
+	return program, nil
+}
+
+// run returns the points the rule gives the receipt, 0 if the condition does not hold.
+// Points that are not whole are rounded up like the item description rule, and clamped to maxCustomRulePoints.
+// The evaluation is stopped after the timeout, the scoring uses expressionTimeout
+func (program *customProgram) run(receipt *exprReceipt, timeout time.Duration) (points int64, err error) {
+	env := &exprEnv{receipt: receipt, timeout: timeout, deadline: time.Now().Add(timeout)}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			failure, ok := recovered.(exprError)
+			if !ok {
+				panic(recovered)
+			}
+			points, err = 0, failure
+		}
+	}()
+	if !program.condition.eval(env).(bool) {
+		return 0, nil
+	}
+	value := program.points.eval(env).(float64)
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, exprError{"the points are not a finite number"}
+	}
+	return int64(math.Ceil(math.Max(-maxCustomRulePoints, math.Min(value, maxCustomRulePoints)))), nil
+}
+
+// Kinds of tokens
+const (
+	tokenEOF = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type exprToken struct {
+	kind   int
+	text   string
+	number float64
+	pos    int
+}
+
+// The operators, longest first so "<=" is not read as "<"
+var exprOperators = []string{"=>", "&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", ",", "."}
+
+// lexExpression splits the expression into tokens
+func lexExpression(source string) ([]exprToken, error) {
+	var tokens []exprToken
+	for pos := 0; pos < len(source); {
+		r, size := utf8.DecodeRuneInString(source[pos:])
+		switch {
+		case unicode.IsSpace(r):
+			pos += size
+		case r >= '0' && r <= '9':
+			end := pos
+			for end < len(source) && (source[end] >= '0' && source[end] <= '9' || source[end] == '.') {
+				end += 1
+			}
+			number, err := strconv.ParseFloat(source[pos:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", source[pos:end], pos)
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: source[pos:end], number: number, pos: pos})
+			pos = end
+		case r == '"':
+			var builder strings.Builder
+			end := pos + 1
+			for ; end < len(source) && source[end] != '"'; end++ {
+				if source[end] == '\\' && end+1 < len(source) {
+					end += 1
+				}
+				builder.WriteByte(source[end])
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("unterminated string at %d", pos)
+			}
+			tokens = append(tokens, exprToken{kind: tokenString, text: builder.String(), pos: pos})
+			pos = end + 1
+		case r == '_' || unicode.IsLetter(r):
+			end := pos
+			for end < len(source) {
+				r, size := utf8.DecodeRuneInString(source[end:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				end += size
+			}
+			tokens = append(tokens, exprToken{kind: tokenIdent, text: source[pos:end], pos: pos})
+			pos = end
+		default:
+			found := false
+			for _, operator := range exprOperators {
+				if strings.HasPrefix(source[pos:], operator) {
+					tokens = append(tokens, exprToken{kind: tokenOperator, text: operator, pos: pos})
+					pos += len(operator)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected %q at %d", r, pos)
+			}
+		}
+	}
+	return append(tokens, exprToken{kind: tokenEOF, pos: len(source)}), nil
+}
+
+// exprParser compiles the tokens into type checked nodes with recursive descent
+type exprParser struct {
+	tokens []exprToken
+	next   int
+	depth  int
+	// inItem is set while compiling the expression inside count, any, all or sum where item is defined
+	inItem bool
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.next]
+}
+
+func (p *exprParser) take() exprToken {
+	token := p.tokens[p.next]
+	if token.kind != tokenEOF {
+		p.next += 1
+	}
+	return token
+}
+
+// isOperator reports whether the next token is one of the operators or word operators
+func (p *exprParser) isOperator(operators ...string) bool {
+	token := p.peek()
+	if token.kind != tokenOperator && token.kind != tokenIdent {
+		return false
+	}
+	for _, operator := range operators {
+		if token.text == operator {
+			return true
+		}
+	}
+	return false
+}
+
+// expect takes the operator or fails
+func (p *exprParser) expect(operator string) error {
+	if !p.isOperator(operator) {
+		return p.unexpected("expected " + operator)
+	}
+	p.take()
+	return nil
+}
+
+// unexpected describes the next token
+func (p *exprParser) unexpected(context string) error {
+	token := p.peek()
+	if token.kind == tokenEOF {
+		return fmt.Errorf("%s at the end", context)
+	}
+	return fmt.Errorf("%s at %d, found %q", context, token.pos, token.text)
+}
+
+// parseRule parses "condition => points"
+func (p *exprParser) parseRule() (*customProgram, error) {
+	condition, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if condition.typ != typeBool {
+		return nil, fmt.Errorf("the condition is a %s, not a bool", condition.typ)
+	}
+	if err := p.expect("=>"); err != nil {
+		return nil, err
+	}
+	points, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if points.typ != typeNumber {
+		return nil, fmt.Errorf("the points are a %s, not a number", points.typ)
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected("expected the end")
+	}
+	return &customProgram{condition: condition, points: points}, nil
+}
+
+// parseExpression parses an expression, the lowest precedence is ||
+func (p *exprParser) parseExpression() (exprNode, error) {
+	p.depth += 1
+	defer func() { p.depth -= 1 }()
+	if p.depth > maxExpressionDepth {
+		return exprNode{}, fmt.Errorf("the expression is nested more than %d levels deep", maxExpressionDepth)
+	}
+	return p.parseOr()
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.isOperator("||") {
+		p.take()
+		var right exprNode
+		if right, err = p.parseAnd(); err == nil {
+			left, err = logical("||", left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseComparison()
+	for err == nil && p.isOperator("&&") {
+		p.take()
+		var right exprNode
+		if right, err = p.parseComparison(); err == nil {
+			left, err = logical("&&", left, right)
+		}
+	}
+	return left, err
+}
+
+// logical compiles || and &&, the right side is only evaluated when it is needed
+func logical(operator string, left exprNode, right exprNode) (exprNode, error) {
+	if left.typ != typeBool || right.typ != typeBool {
+		return exprNode{}, fmt.Errorf("%s needs bools, not %s and %s", operator, left.typ, right.typ)
+	}
+	if operator == "||" {
+		return exprNode{typeBool, func(env *exprEnv) any {
+			env.step()
+			return left.eval(env).(bool) || right.eval(env).(bool)
+		}}, nil
+	}
+	return exprNode{typeBool, func(env *exprEnv) any {
+		env.step()
+		return left.eval(env).(bool) && right.eval(env).(bool)
+	}}, nil
+}
+
+// parseComparison parses one comparison, comparisons can not be chained
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseSum()
+	if err != nil || !p.isOperator("==", "!=", "<", "<=", ">", ">=", "contains", "startsWith", "endsWith") {
+		return left, err
+	}
+	operator := p.take().text
+	right, err := p.parseSum()
+	if err != nil {
+		return left, err
+	}
+	if left.typ != right.typ || left.typ == typeItems {
+		return left, fmt.Errorf("%s can not compare %s with %s", operator, left.typ, right.typ)
+	}
+
+	switch operator {
+	case "==", "!=":
+		equal := operator == "=="
+		return exprNode{typeBool, func(env *exprEnv) any {
+			env.step()
+			return (left.eval(env) == right.eval(env)) == equal
+		}}, nil
+	case "contains", "startsWith", "endsWith":
+		if left.typ != typeString {
+			return left, fmt.Errorf("%s needs strings, not %s", operator, left.typ)
+		}
+		test := map[string]func(string, string) bool{"contains": strings.Contains, "startsWith": strings.HasPrefix, "endsWith": strings.HasSuffix}[operator]
+		return exprNode{typeBool, func(env *exprEnv) any {
+			env.step()
+			return test(left.eval(env).(string), right.eval(env).(string))
+		}}, nil
+	}
+	if left.typ != typeNumber && left.typ != typeString {
+		return left, fmt.Errorf("%s needs numbers or strings, not %s", operator, left.typ)
+	}
+	return exprNode{typeBool, func(env *exprEnv) any {
+		env.step()
+		a, b := left.eval(env), right.eval(env)
+		var order int
+		if left.typ == typeNumber {
+			order = compareNumbers(a.(float64), b.(float64))
+		} else {
+			order = strings.Compare(a.(string), b.(string))
+		}
+		switch operator {
+		case "<":
+			return order < 0
+		case "<=":
+			return order <= 0
+		case ">":
+			return order > 0
+		default:
+			return order >= 0
+		}
+	}}, nil
+}
+
+// compareNumbers returns -1, 0 or 1
+func compareNumbers(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	for err == nil && p.isOperator("+", "-") {
+		operator := p.take().text
+		var right exprNode
+		if right, err = p.parseProduct(); err == nil {
+			left, err = arithmetic(operator, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.isOperator("*", "/", "%") {
+		operator := p.take().text
+		var right exprNode
+		if right, err = p.parseUnary(); err == nil {
+			left, err = arithmetic(operator, left, right)
+		}
+	}
+	return left, err
+}
+
+// arithmetic compiles + - * / %, + also joins strings
+func arithmetic(operator string, left exprNode, right exprNode) (exprNode, error) {
+	if operator == "+" && left.typ == typeString && right.typ == typeString {
+		return exprNode{typeString, func(env *exprEnv) any {
+			env.step()
+			return left.eval(env).(string) + right.eval(env).(string)
+		}}, nil
+	}
+	if left.typ != typeNumber || right.typ != typeNumber {
+		return exprNode{}, fmt.Errorf("%s needs numbers, not %s and %s", operator, left.typ, right.typ)
+	}
+	return exprNode{typeNumber, func(env *exprEnv) any {
+		env.step()
+		a, b := left.eval(env).(float64), right.eval(env).(float64)
+		switch operator {
+		case "+":
+			return a + b
+		case "-":
+			return a - b
+		case "*":
+			return a * b
+		}
+		if b == 0 {
+			panic(exprError{"division by zero"})
+		}
+		if operator == "/" {
+			return a / b
+		}
+		return math.Mod(a, b)
+	}}, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if !p.isOperator("!", "-") {
+		return p.parsePrimary()
+	}
+	operator := p.take().text
+	operand, err := p.parseUnary()
+	if err != nil {
+		return operand, err
+	}
+	if operator == "!" {
+		if operand.typ != typeBool {
+			return operand, fmt.Errorf("! needs a bool, not %s", operand.typ)
+		}
+		return exprNode{typeBool, func(env *exprEnv) any {
+			env.step()
+			return !operand.eval(env).(bool)
+		}}, nil
+	}
+	if operand.typ != typeNumber {
+		return operand, fmt.Errorf("- needs a number, not %s", operand.typ)
+	}
+	return exprNode{typeNumber, func(env *exprEnv) any {
+		env.step()
+		return -operand.eval(env).(float64)
+	}}, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	token := p.peek()
+	switch token.kind {
+	case tokenNumber:
+		p.take()
+		return exprNode{typeNumber, func(env *exprEnv) any { return token.number }}, nil
+	case tokenString:
+		p.take()
+		return exprNode{typeString, func(env *exprEnv) any { return token.text }}, nil
+	case tokenIdent:
+		p.take()
+		switch {
+		case token.text == "true" || token.text == "false":
+			value := token.text == "true"
+			return exprNode{typeBool, func(env *exprEnv) any { return value }}, nil
+		case p.isOperator("("):
+			return p.parseCall(token.text)
+		case token.text == "item":
+			if !p.inItem {
+				return exprNode{}, fmt.Errorf("item can only be used inside count, any, all and sum")
+			}
+			if err := p.expect("."); err != nil {
+				return exprNode{}, err
+			}
+			field := p.take()
+			node, ok := exprItemFields[field.text]
+			if !ok {
+				return exprNode{}, fmt.Errorf("items have no field %q", field.text)
+			}
+			return node, nil
+		}
+		node, ok := exprVariables[token.text]
+		if !ok {
+			return exprNode{}, fmt.Errorf("unknown name %q at %d", token.text, token.pos)
+		}
+		return node, nil
+	case tokenOperator:
+		if token.text == "(" {
+			p.take()
+			node, err := p.parseExpression()
+			if err != nil {
+				return node, err
+			}
+			return node, p.expect(")")
+		}
+	}
+	return exprNode{}, p.unexpected("expected a value")
+}
+
+// parseCall parses the arguments of a function and checks their types
+func (p *exprParser) parseCall(name string) (exprNode, error) {
+	p.take()
+	var args []exprNode
+	for !p.isOperator(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return exprNode{}, err
+			}
+		}
+		// The second argument of the item functions is evaluated for each item
+		inItem := p.inItem
+		p.inItem = inItem || (len(args) == 1 && (name == "count" || name == "any" || name == "all" || name == "sum"))
+		arg, err := p.parseExpression()
+		p.inItem = inItem
+		if err != nil {
+			return arg, err
+		}
+		args = append(args, arg)
+	}
+	p.take()
+
+	types := make([]exprType, len(args))
+	for i, arg := range args {
+		types[i] = arg.typ
+	}
+	signature := func(want ...exprType) error {
+		if len(types) != len(want) {
+			return fmt.Errorf("%s takes %d arguments, not %d", name, len(want), len(types))
+		}
+		for i := range want {
+			if types[i] != want[i] {
+				return fmt.Errorf("argument %d of %s is a %s, not a %s", i+1, name, types[i], want[i])
+			}
+		}
+		return nil
+	}
+
+	switch name {
+	case "len":
+		if len(args) == 1 && args[0].typ == typeItems {
+			return exprNode{typeNumber, func(env *exprEnv) any {
+				env.step()
+				return float64(len(args[0].eval(env).([]exprItem)))
+			}}, nil
+		}
+		if err := signature(typeString); err != nil {
+			return exprNode{}, err
+		}
+		return exprNode{typeNumber, func(env *exprEnv) any {
+			env.step()
+			return float64(utf8.RuneCountInString(args[0].eval(env).(string)))
+		}}, nil
+	case "lower", "upper", "trim":
+		if err := signature(typeString); err != nil {
+			return exprNode{}, err
+		}
+		convert := map[string]func(string) string{"lower": strings.ToLower, "upper": strings.ToUpper, "trim": strings.TrimSpace}[name]
+		return exprNode{typeString, func(env *exprEnv) any {
+			env.step()
+			return convert(args[0].eval(env).(string))
+		}}, nil
+	case "floor", "ceil", "round", "abs":
+		if err := signature(typeNumber); err != nil {
+			return exprNode{}, err
+		}
+		convert := map[string]func(float64) float64{"floor": math.Floor, "ceil": math.Ceil, "round": math.Round, "abs": math.Abs}[name]
+		return exprNode{typeNumber, func(env *exprEnv) any {
+			env.step()
+			return convert(args[0].eval(env).(float64))
+		}}, nil
+	case "min", "max":
+		if err := signature(typeNumber, typeNumber); err != nil {
+			return exprNode{}, err
+		}
+		pick := map[string]func(float64, float64) float64{"min": math.Min, "max": math.Max}[name]
+		return exprNode{typeNumber, func(env *exprEnv) any {
+			env.step()
+			return pick(args[0].eval(env).(float64), args[1].eval(env).(float64))
+		}}, nil
+	case "count", "any", "all":
+		if err := signature(typeItems, typeBool); err != nil {
+			return exprNode{}, err
+		}
+		typ := typeBool
+		if name == "count" {
+			typ = typeNumber
+		}
+		return exprNode{typ, func(env *exprEnv) any {
+			env.step()
+			var matched float64
+			items := args[0].eval(env).([]exprItem)
+			outer := env.item
+			defer func() { env.item = outer }()
+			for i := range items {
+				env.item = &items[i]
+				if args[1].eval(env).(bool) {
+					matched += 1
+				}
+			}
+			switch name {
+			case "any":
+				return matched > 0
+			case "all":
+				return matched == float64(len(items))
+			}
+			return matched
+		}}, nil
+	case "sum":
+		if err := signature(typeItems, typeNumber); err != nil {
+			return exprNode{}, err
+		}
+		return exprNode{typeNumber, func(env *exprEnv) any {
+			env.step()
+			var total float64
+			items := args[0].eval(env).([]exprItem)
+			outer := env.item
+			defer func() { env.item = outer }()
+			for i := range items {
+				env.item = &items[i]
+				total += args[1].eval(env).(float64)
+			}
+			return total
+		}}, nil
+	}
+	return exprNode{}, fmt.Errorf("unknown function %q", name)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runExpression compiles the rule and runs it on the receipt
+func runExpression(t *testing.T, expression string, receipt Receipt) int64 {
+	program, err := compileCustomRule(CustomRule{Name: "test", Expression: expression})
+	if err != nil {
+		t.Fatalf("compileCustomRule(%s): %v", expression, err)
+	}
+	points, err := program.run(newExprReceipt(receipt), expressionTimeout)
+	assert.NoError(t, err)
+	return points
+}
+
+// TestExpressionRules
+func TestExpressionRules(t *testing.T) {
+	// validReceipt1 is from Target on 2022-01-01 at 02:01 with five items and a total of 35.35
+	tests := map[string]int64{
+		`retailer contains "Target" && len(items) >= 5 => 15`:         15,
+		`retailer contains "Walmart" || len(items) > 5 => 15`:         0,
+		`lower(retailer) == "target" => total`:                        36,
+		`total > 35 && total < 35.5 => 1`:                             1,
+		`date == "2022-01-01" && weekday == "Saturday" => 7`:          7,
+		`year == 2022 && month == 1 && day == 1 && hour < 3 => 3`:     3,
+		`time >= "02:00" && minute == 1 => 2`:                         2,
+		`any(items, item.description contains "Pizza") => 4`:          4,
+		`all(items, item.price > 1) => 4`:                             4,
+		`!all(items, item.price > 5) => count(items, item.price > 5)`: 3,
+		`true => sum(items, item.price) * 0.1`:                        4,
+		`true => floor(total) + max(1, 2) - min(1, 2) % 1`:            37,
+		`retailer startsWith "Tar" && retailer endsWith "get" => -2`:  -2,
+		`-total < 0 && (1 + 2) * 3 == 9 => round(2.5)`:                3,
+		`upper(retailer + "!") == "TARGET!" => abs(-4)`:               4,
+	}
+	for expression, expected := range tests {
+		assert.Equal(t, expected, runExpression(t, expression, validReceipt1), expression)
+	}
+}
+
+// TestExpressionTypeErrors
+func TestExpressionTypeErrors(t *testing.T) {
+	tests := map[string]string{
+		`total => 5`:                     "custom rule bad: the condition is a number, not a bool",
+		`true => "five"`:                 "custom rule bad: the points are a string, not a number",
+		`retailer > 5 => 1`:              "custom rule bad: > can not compare string with number",
+		`total contains "1" => 1`:        "custom rule bad: contains can not compare number with string",
+		`item.price > 5 => 1`:            "custom rule bad: item can only be used inside count, any, all and sum",
+		`any(items, item.size) => 1`:     `custom rule bad: items have no field "size"`,
+		`count(items) > 1 => 1`:          "custom rule bad: count takes 2 arguments, not 1",
+		`sum(items, true) > 1 => 1`:      "custom rule bad: argument 2 of sum is a bool, not a number",
+		`exec("rm") => 1`:                `custom rule bad: unknown function "exec"`,
+		`customer == "x" => 1`:           `custom rule bad: unknown name "customer" at 0`,
+		`true => 1 1`:                    `custom rule bad: expected the end at 10, found "1"`,
+		`true`:                           "custom rule bad: expected => at the end",
+		`retailer == "Target => 1`:       "custom rule bad: unterminated string at 12",
+		`true && $ => 1`:                 `custom rule bad: unexpected '$' at 8`,
+		`startsWith(retailer, "T") => 1`: `custom rule bad: unknown function "startsWith"`,
+		`items == items => 1`:            "custom rule bad: == can not compare items with items",
+		`true => (((((((((((((((((((((((((((((((((1)))))))))))))))))))))))))))))))))`: "custom rule bad: the expression is nested more than 32 levels deep",
+	}
+	for expression, expected := range tests {
+		_, err := compileCustomRule(CustomRule{Name: "bad", Expression: expression})
+		if assert.Error(t, err, expression) {
+			assert.Equal(t, expected, err.Error(), expression)
+		}
+	}
+}
+
+// TestExpressionLimits
+func TestExpressionLimits(t *testing.T) {
+	receipt := validReceipt1
+	receipt.Items = make([]Item, 500)
+	for i := range receipt.Items {
+		receipt.Items[i] = Item{ShortDescription: "Gum", Price: "1.00"}
+	}
+	program, err := compileCustomRule(CustomRule{Name: "slow", Expression: `count(items, any(items, item.price > 2)) == 0 => 1`})
+	assert.NoError(t, err)
+	// The step limit is checked with a timeout that a slow machine can not reach
+	points, err := program.run(newExprReceipt(receipt), time.Minute)
+	assert.Equal(t, int64(0), points)
+	assert.EqualError(t, err, "the expression took more than 100000 steps")
+
+	_, err = program.run(newExprReceipt(receipt), time.Nanosecond)
+	assert.EqualError(t, err, "the expression took longer than 1ns")
+
+	program, _ = compileCustomRule(CustomRule{Name: "zero", Expression: `true => total / (len(items) - 5)`})
+	points, err = program.run(newExprReceipt(validReceipt1), expressionTimeout)
+	assert.Equal(t, int64(0), points)
+	assert.EqualError(t, err, "division by zero")
+
+	// Points overflow to infinity before they are clamped
+	huge := "1" + strings.Repeat("0", 200)
+	program, _ = compileCustomRule(CustomRule{Name: "huge", Expression: "true => " + huge + " * " + huge})
+	points, err = program.run(newExprReceipt(validReceipt1), expressionTimeout)
+	assert.Equal(t, int64(0), points)
+	assert.EqualError(t, err, "the points are not a finite number")
+	assert.Equal(t, int64(maxCustomRulePoints), runExpression(t, "true => "+huge, validReceipt1))
+	assert.Equal(t, int64(-maxCustomRulePoints), runExpression(t, "true => -"+huge, validReceipt1))
+}
+
+// TestCustomRulesInRuleSet
+func TestCustomRulesInRuleSet(t *testing.T) {
+	rules := defaultRuleSet()
+	rules.CustomRules = []CustomRule{{Name: "bigTarget", Expression: `retailer contains "Target" && len(items) >= 5 => 15`}}
+	assert.NoError(t, rules.validate())
+
+	breakdown := ruleBreakdown(validReceipt1, rules)
+	assert.Equal(t, RulePoints{Rule: "bigTarget", Points: 15}, breakdown.Rules[len(breakdown.Rules)-1])
+	assert.Equal(t, int64(43), breakdown.Base)
+	assert.Equal(t, int64(109), ruleBreakdown(validReceipt2, rules).Base)
+
+	rules.CustomRules = []CustomRule{{Name: "roundDollar", Expression: `true => 1`}}
+	assert.EqualError(t, rules.validate(), "rule set 1: custom rules need a name that is not used by another rule")
+	rules.CustomRules = []CustomRule{{Name: "broken", Expression: `true => "1"`}}
+	assert.EqualError(t, rules.validate(), "rule set 1: custom rule broken: the points are a string, not a number")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The stored forms of the purchase date and time, the only ones accepted in strict mode
+const (
+	canonicalDateLayout = "2006-01-02"
+	canonicalTimeLayout = "15:04"
+)
+
+// Orders of the day and month in dates written with slashes
+const (
+	dateOrderMonthFirst = "MDY"
+	dateOrderDayFirst   = "DMY"
+)
+
+// InputFormatsConfig sets which date and time layouts are accepted and turned into the stored forms
+type InputFormatsConfig struct {
+	// Strict only accepts "2006-01-02" and "15:04" as api.yml describes
+	Strict bool `json:"strict"`
+	// DateOrder is "MDY" to read 03/04/2022 as March 4 or "DMY" to read it as April 3.
+	// Dates with slashes are not accepted when it is empty, so they are never guessed
+	DateOrder string `json:"dateOrder"`
+	// DateLayouts and TimeLayouts are more Go layouts to accept, like "Jan 2 2006" or "15.04"
+	DateLayouts []string `json:"dateLayouts"`
+	TimeLayouts []string `json:"timeLayouts"`
+}
+
+// Layouts accepted when strict mode is off, the canonical ones are always accepted
+var (
+	flexibleTimeLayouts = []string{"15:04:05", "3:04 PM", "3:04PM", "3:04:05 PM", "3:04:05PM"}
+	slashDateLayouts    = map[string][]string{
+		dateOrderMonthFirst: {"01/02/2006", "1/2/2006"},
+		dateOrderDayFirst:   {"02/01/2006", "2/1/2006"},
+	}
+	// ISO 8601 timestamps can be given as the date, the time or both, with or without an offset
+	timestampLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04"}
+)
+
+// validate checks the date order and that the layouts read a whole date or time
+func (cfg InputFormatsConfig) validate() error {
+	if _, ok := slashDateLayouts[cfg.DateOrder]; cfg.DateOrder != "" && !ok {
+		return fmt.Errorf("unknown date order %q, use MDY or DMY", cfg.DateOrder)
+	}
+	// Formatting a date and reading it back only gives the same date if the layout has the year, month and day
+	reference := time.Date(2022, time.March, 20, 14, 33, 0, 0, time.UTC)
+	for _, layout := range cfg.DateLayouts {
+		parsed, err := time.Parse(layout, reference.Format(layout))
+		if err != nil || parsed.Format(canonicalDateLayout) != reference.Format(canonicalDateLayout) {
+			return fmt.Errorf("date layout %q needs the year, month and day", layout)
+		}
+	}
+	for _, layout := range cfg.TimeLayouts {
+		parsed, err := time.Parse(layout, reference.Format(layout))
+		if err != nil || parsed.Format(canonicalTimeLayout) != reference.Format(canonicalTimeLayout) {
+			return fmt.Errorf("time layout %q needs the hour and minute", layout)
+		}
+	}
+	return nil
+}
+
+// parseTimestamp reads an ISO 8601 timestamp, one with an offset is moved to the store's time zone
+func parseTimestamp(value string, location *time.Location) (time.Time, bool) {
+	for _, layout := range timestampLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			if layout == time.RFC3339 {
+				parsed = parsed.In(location)
+			}
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseWithLayouts reads the value with the first layout that fits
+func parseWithLayouts(value string, layouts []string) (time.Time, bool) {
+	for _, layout := range layouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// normalizePurchase rewrites the purchase date and time in the stored forms. Values that can not be read
+// are left as they are, so the validators reject them as before
+func normalizePurchase(receipt *Receipt, cfg InputFormatsConfig, timeZones TimeZonesConfig) {
+	if cfg.Strict {
+		return
+	}
+	location, err := loadTimeZone(storeTimeZone(*receipt, timeZones))
+	if err != nil {
+		return
+	}
+
+	date := strings.TrimSpace(receipt.PurchaseDate)
+	dateLayouts := append([]string{canonicalDateLayout}, slashDateLayouts[cfg.DateOrder]...)
+	if parsed, ok := parseTimestamp(date, location); ok {
+		receipt.PurchaseDate = parsed.Format(canonicalDateLayout)
+	} else if parsed, ok := parseWithLayouts(date, append(dateLayouts, cfg.DateLayouts...)); ok {
+		receipt.PurchaseDate = parsed.Format(canonicalDateLayout)
+	}
+
+	purchaseTime := strings.TrimSpace(receipt.PurchaseTime)
+	if parsed, ok := parseTimestamp(purchaseTime, location); ok {
+		receipt.PurchaseTime = parsed.Format(canonicalTimeLayout)
+		return
+	}
+	// AM and PM are read in any case, seconds are dropped as the rules only look at the minute
+	parsed, ok := parseWithLayouts(strings.ToUpper(purchaseTime), append([]string{canonicalTimeLayout}, flexibleTimeLayouts...))
+	if !ok {
+		parsed, ok = parseWithLayouts(purchaseTime, cfg.TimeLayouts)
+	}
+	if ok {
+		receipt.PurchaseTime = parsed.Format(canonicalTimeLayout)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// normalized returns the purchase date and time of validReceipt2 with the inputs after normalizePurchase
+func normalized(date string, purchaseTime string, cfg InputFormatsConfig, timeZones TimeZonesConfig) (string, string) {
+	receipt := validReceipt2
+	receipt.PurchaseDate = date
+	receipt.PurchaseTime = purchaseTime
+	normalizePurchase(&receipt, cfg, timeZones)
+	return receipt.PurchaseDate, receipt.PurchaseTime
+}
+
+// TestNormalizePurchase
+func TestNormalizePurchase(t *testing.T) {
+	monthFirst := InputFormatsConfig{DateOrder: dateOrderMonthFirst}
+	tests := []struct {
+		name, date, time, expectedDate, expectedTime string
+		cfg                                          InputFormatsConfig
+	}{
+		{"canonical", "2022-03-20", "14:33", "2022-03-20", "14:33", monthFirst},
+		{"month first", "03/20/2022", "2:33 PM", "2022-03-20", "14:33", monthFirst},
+		{"short month first", "3/4/2022", "2:33pm", "2022-03-04", "14:33", monthFirst},
+		{"day first", "03/04/2022", "12:05 am", "2022-04-03", "00:05", InputFormatsConfig{DateOrder: dateOrderDayFirst}},
+		{"seconds", "2022-03-20", "14:33:05", "2022-03-20", "14:33", monthFirst},
+		{"seconds with PM", "2022-03-20", "2:33:59 PM", "2022-03-20", "14:33", monthFirst},
+		{"timestamp", "2022-03-20T14:33:05", "2022-03-20T14:33:05", "2022-03-20", "14:33", monthFirst},
+		{"UTC timestamp", "2022-03-20T23:30:00Z", "2022-03-20T23:30:00Z", "2022-03-20", "23:30", monthFirst},
+		{"custom layouts", "Mar 20 2022", "14h33", "2022-03-20", "14:33", InputFormatsConfig{DateLayouts: []string{"Jan 2 2006"}, TimeLayouts: []string{"15h04"}}},
+		// Without a date order dates with slashes are not guessed and are rejected later
+		{"no date order", "03/04/2022", "14:33", "03/04/2022", "14:33", InputFormatsConfig{}},
+		{"unreadable", "yesterday", "teatime", "yesterday", "teatime", monthFirst},
+		{"strict", "03/20/2022", "2:33 PM", "03/20/2022", "2:33 PM", InputFormatsConfig{Strict: true, DateOrder: dateOrderMonthFirst}},
+	}
+	for _, test := range tests {
+		date, purchaseTime := normalized(test.date, test.time, test.cfg, TimeZonesConfig{})
+		assert.Equal(t, test.expectedDate, date, test.name)
+		assert.Equal(t, test.expectedTime, purchaseTime, test.name)
+	}
+
+	// A timestamp with an offset is moved to the store's time zone, here across midnight
+	date, purchaseTime := normalized("2022-03-21T03:30:00Z", "2022-03-21T03:30:00Z", monthFirst, TimeZonesConfig{Default: "America/Chicago"})
+	assert.Equal(t, "2022-03-20", date)
+	assert.Equal(t, "22:30", purchaseTime)
+}
+
+// TestInputFormatsConfigValidate
+func TestInputFormatsConfigValidate(t *testing.T) {
+	assert.NoError(t, InputFormatsConfig{DateOrder: dateOrderDayFirst, DateLayouts: []string{"2.1.2006"}, TimeLayouts: []string{"3.04pm"}}.validate())
+	assert.EqualError(t, InputFormatsConfig{DateOrder: "en-US"}.validate(), `unknown date order "en-US", use MDY or DMY`)
+	assert.EqualError(t, InputFormatsConfig{DateLayouts: []string{"01/02"}}.validate(), `date layout "01/02" needs the year, month and day`)
+	assert.EqualError(t, InputFormatsConfig{TimeLayouts: []string{"15"}}.validate(), `time layout "15" needs the hour and minute`)
+}
+
+// TestFlexibleInputFormats
+func TestFlexibleInputFormats(t *testing.T) {
+	setup()
+	receipt := validReceipt2
+	receipt.PurchaseDate = "03/20/2022"
+	receipt.PurchaseTime = "2:33 PM"
+
+	// Strict mode is the default and keeps api.yml's formats
+	router := newTestRouter(t, defaultConfig())
+	w := serveJSON(router, "POST", "/receipts/process", receipt)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The receipt is invalid.", w.Body.String())
+
+	cfg := defaultConfig()
+	cfg.InputFormats = InputFormatsConfig{DateOrder: dateOrderMonthFirst}
+	router = newTestRouter(t, cfg)
+	w = serveJSON(router, "POST", "/receipts/process", receipt)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2022-03-20", receiptsMap["Receipt1"].PurchaseDate)
+	assert.Equal(t, "14:33", receiptsMap["Receipt1"].PurchaseTime)
+	// The points are the same as for the canonical receipt
+	assert.Equal(t, int64(109), receiptsMap["Receipt1"].Breakdown.Total)
+
+	receipt.PurchaseTime = "25:00 PM"
+	assert.Equal(t, http.StatusBadRequest, serveJSON(router, "POST", "/receipts/process", receipt).Code)
+
+	teardown()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// How the itemPairs rule counts items
+const (
+	// pairCountingLines counts every line of the receipt as one item, as the rules were first released
+	pairCountingLines = "lines"
+	// pairCountingUnits counts the quantity of every line, a weighed item with a fraction is one unit
+	pairCountingUnits = "units"
+)
+
+// Bounds of the optional item fields
+const (
+	maxItemQuantity = 1000000
+	maxSKULength    = 64
+)
+
+// ReceiptDiscount is a discount of the whole receipt, like a coupon
+type ReceiptDiscount struct {
+	Description string `json:"description" binding:"required" validate:"validText"`
+	Amount      string `json:"amount" binding:"required" validate:"regexp=^\\d+(\\.\\d+)?$"`
+}
+
+// parseDecimal reads a validated amount or quantity exactly, an empty value is 0
+func parseDecimal(value string) *big.Rat {
+	number := new(big.Rat)
+	if value != "" {
+		number.SetString(value)
+	}
+	return number
+}
+
+// itemQuantity returns the quantity of the item, 1 when it is left out
+func itemQuantity(item Item) *big.Rat {
+	if item.Quantity == "" {
+		return big.NewRat(1, 1)
+	}
+	return parseDecimal(item.Quantity)
+}
+
+// validGTIN reports whether the last digit of the barcode is the GS1 check digit of the others
+func validGTIN(code string) bool {
+	sum := 0
+	for i := len(code) - 2; i >= 0; i-- {
+		digit := int(code[i] - '0')
+		// Digits are weighted 3, 1, 3, ... from the right, not counting the check digit
+		if (len(code)-2-i)%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return int(code[len(code)-1]-'0') == (10-sum%10)%10
+}
+
+// checkLineItems checks the quantities, unit prices, discounts and taxes add up to the prices and the total.
+// The total is only checked when the receipt gives a tax, a tip or discounts, as receipts without them
+// often leave lines out. The amounts already have the decimals of the receipt's currency
+func checkLineItems(receipt Receipt, units int) error {
+	prices := new(big.Rat)
+	itemTaxes := new(big.Rat)
+	itemised := receipt.Tax != "" || receipt.Tip != "" || len(receipt.Discounts) > 0
+	for i, item := range receipt.Items {
+		quantity := itemQuantity(item)
+		if quantity.Sign() <= 0 || quantity.Cmp(big.NewRat(maxItemQuantity, 1)) > 0 {
+			return fmt.Errorf("items[%d].quantity must be more than 0 and at most %d", i, maxItemQuantity)
+		}
+		if len(item.SKU) > maxSKULength {
+			return fmt.Errorf("items[%d].sku is longer than %d characters", i, maxSKULength)
+		}
+		if item.UPC != "" && !validGTIN(item.UPC) {
+			return fmt.Errorf("items[%d].upc has the wrong check digit", i)
+		}
+		if item.UnitPrice != "" {
+			expected := new(big.Rat).Mul(quantity, parseDecimal(item.UnitPrice))
+			expected.Sub(expected, parseDecimal(item.Discount))
+			if expected.FloatString(units) != parseDecimal(item.Price).FloatString(units) {
+				return fmt.Errorf("items[%d].price should be %s, the quantity times the unit price less the discount", i, expected.FloatString(units))
+			}
+		}
+		prices.Add(prices, parseDecimal(item.Price))
+		itemTaxes.Add(itemTaxes, parseDecimal(item.Tax))
+		itemised = itemised || item.Tax != ""
+	}
+	if !itemised {
+		return nil
+	}
+
+	// The tax of the receipt is the tax of the items when it is left out, and has to match it when both are given
+	tax := itemTaxes
+	if receipt.Tax != "" {
+		tax = parseDecimal(receipt.Tax)
+		if itemTaxes.Sign() > 0 && itemTaxes.Cmp(tax) != 0 {
+			return fmt.Errorf("the item taxes add up to %s, not the tax of %s", itemTaxes.FloatString(units), receipt.Tax)
+		}
+	}
+	expected := new(big.Rat).Add(prices, tax)
+	expected.Add(expected, parseDecimal(receipt.Tip))
+	for _, discount := range receipt.Discounts {
+		expected.Sub(expected, parseDecimal(discount.Amount))
+	}
+	if expected.FloatString(units) != parseDecimal(receipt.Total).FloatString(units) {
+		return fmt.Errorf("the total should be %s, the prices plus the tax and tip less the discounts", expected.FloatString(units))
+	}
+	return nil
+}
+
+// countUnits returns the number of units on the receipt, lines with a fraction of a unit count as one
+func countUnits(items []Item) int64 {
+	var units int64 = 0
+	for _, item := range items {
+		quantity := itemQuantity(item)
+		if quantity.IsInt() {
+			units += quantity.Num().Int64()
+		} else {
+			units++
+		}
+	}
+	return units
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// itemisedReceipt returns validReceipt2 with its four Gatorade bought on one line of 4 x 2.25, plus a tax and a tip
+func itemisedReceipt() Receipt {
+	receipt := validReceipt2
+	receipt.Items = []Item{
+		{ShortDescription: "Gatorade", Price: "8.00", Quantity: "4", UnitPrice: "2.25", Discount: "1.00", SKU: "GAT-32-BLUE", UPC: "052000328660", Tax: "0.64"},
+		{ShortDescription: "Bananas", Price: "1.36", Quantity: "2.72", UnitPrice: "0.50"},
+	}
+	receipt.Tip = "1.00"
+	receipt.Discounts = []ReceiptDiscount{{Description: "Coupon", Amount: "2.00"}}
+	// 8.00 + 1.36 + 0.64 tax + 1.00 tip - 2.00 coupon
+	receipt.Total = "9.00"
+	return receipt
+}
+
+// TestCheckLineItems
+func TestCheckLineItems(t *testing.T) {
+	assert.NoError(t, checkLineItems(itemisedReceipt(), 2))
+	// Receipts without a tax, a tip or discounts do not have to add up, like before
+	assert.NoError(t, checkLineItems(receiptInvalidTotal, 2))
+
+	tests := map[string]func(receipt *Receipt){
+		"items[0].price should be 9.00, the quantity times the unit price less the discount": func(receipt *Receipt) { receipt.Items[0].Discount = "" },
+		"items[1].quantity must be more than 0 and at most 1000000":                          func(receipt *Receipt) { receipt.Items[1].Quantity = "0" },
+		"items[0].upc has the wrong check digit":                                             func(receipt *Receipt) { receipt.Items[0].UPC = "052000328661" },
+		"the total should be 11.00, the prices plus the tax and tip less the discounts":      func(receipt *Receipt) { receipt.Discounts = nil },
+		"the item taxes add up to 0.64, not the tax of 0.70":                                 func(receipt *Receipt) { receipt.Tax = "0.70" },
+	}
+	for expected, change := range tests {
+		receipt := itemisedReceipt()
+		receipt.Items = append([]Item{}, receipt.Items...)
+		change(&receipt)
+		assert.EqualError(t, checkLineItems(receipt, 2), expected)
+	}
+
+	// The receipt's tax can be given without the item taxes
+	receipt := itemisedReceipt()
+	receipt.Items = append([]Item{}, receipt.Items...)
+	receipt.Items[0].Tax = ""
+	receipt.Tax = "0.64"
+	assert.NoError(t, checkLineItems(receipt, 2))
+
+	assert.True(t, validGTIN("96385074"))
+	assert.True(t, validGTIN("4006381333931"))
+	assert.False(t, validGTIN("4006381333932"))
+}
+
+// TestLineItems
+func TestLineItems(t *testing.T) {
+	setup()
+	router := newTestRouter(t, defaultConfig())
+
+	var created ReceiptCreatedResponse
+	w := serveJSON(router, "POST", "/receipts/process", itemisedReceipt())
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &created)
+	assert.Equal(t, "GAT-32-BLUE", receiptsMap[created.ID].Items[0].SKU)
+
+	invalid := map[string]func(receipt *Receipt){
+		"The receipt is invalid: the total should be 10.00, the prices plus the tax and tip less the discounts.": func(receipt *Receipt) { receipt.Tip = "2.00" },
+		"The receipt is invalid.": func(receipt *Receipt) { receipt.Items[0].Quantity = "four" },
+	}
+	for expected, change := range invalid {
+		receipt := itemisedReceipt()
+		receipt.Items = append([]Item{}, receipt.Items...)
+		change(&receipt)
+		w := serveJSON(router, "POST", "/receipts/process", receipt)
+		assert.Equal(t, http.StatusBadRequest, w.Code, expected)
+		assert.Equal(t, expected, w.Body.String())
+	}
+
+	// Amounts other than unit prices need the decimals of the currency
+	receipt := itemisedReceipt()
+	receipt.Tip = "1.0"
+	assert.Equal(t, "The receipt is invalid.", serveJSON(router, "POST", "/receipts/process", receipt).Body.String())
+
+	teardown()
+}
+
+// TestPairCounting
+func TestPairCounting(t *testing.T) {
+	items := []Item{
+		{ShortDescription: "Gatorade", Price: "9.00", Quantity: "4", UnitPrice: "2.25"},
+		{ShortDescription: "Bananas", Price: "1.36", Quantity: "2.72", UnitPrice: "0.50"},
+		{ShortDescription: "Bread", Price: "3.00"},
+	}
+	rules := defaultRuleSet()
+	assert.Equal(t, int64(5), rules.pairsPoints(items))
+	rules.PairCounting = pairCountingUnits
+	// 4 Gatorade, the weighed bananas and the bread are 6 units
+	assert.Equal(t, int64(15), rules.pairsPoints(items))
+
+	setup()
+	path := filepath.Join(t.TempDir(), "rules.json")
+	os.WriteFile(path, []byte(`{"ruleSets": [{"version": "2", "pairCounting": "pairs"}]}`), 0600)
+	_, err := loadRuleSets(path)
+	assert.EqualError(t, err, `rule set 2: pairCounting must be "lines" or "units"`)
+
+	os.WriteFile(path, []byte(`{"active": "2", "ruleSets": [{"version": "2", "pairCounting": "units"}]}`), 0600)
+	cfg := defaultConfig()
+	cfg.RulesFile = path
+	router := newTestRouter(t, cfg)
+	var score ReceiptScoreResponse
+	receipt := itemisedReceipt()
+	json.Unmarshal(serveJSON(router, "POST", "/receipts/score", receipt).Body.Bytes(), &score)
+	// validReceipt2 scores 109 with four lines, here the two lines are five units and still two pairs
+	assert.Equal(t, int64(109), score.Points)
+
+	teardown()
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Statuses of a job
+const (
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobCancelled = "cancelled"
+)
+
+// RescoreFilter picks the receipts a re-scoring job looks at, empty fields match every receipt
+type RescoreFilter struct {
+	// Retailer matches the printed or the canonical retailer name, ignoring case and surrounding spaces
+	Retailer   string `json:"retailer,omitempty"`
+	CustomerID string `json:"customerId,omitempty"`
+	// FromDate and ToDate are inclusive purchase dates
+	FromDate string `json:"fromDate,omitempty"`
+	ToDate   string `json:"toDate,omitempty"`
+	// RuleVersion matches the receipts that were last scored with that version
+	RuleVersion string `json:"ruleVersion,omitempty"`
+	// Tenant matches the receipts of the tenant, it is always the tenant of the request when there is one
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// RescoreJobRequest starts a job that scores the stored receipts again with the rule set in Version
+type RescoreJobRequest struct {
+	// Version is the rule set to score with. By default each receipt is scored with the rules it would get
+	// when processed now, the active rule set or an override
+	Version string `json:"version"`
+	// DryRun only reports the differences, the receipts and the ledger are left as they are
+	DryRun bool          `json:"dryRun"`
+	Filter RescoreFilter `json:"filter"`
+}
+
+// RescoreDiff is a receipt whose points change under the new rules
+type RescoreDiff struct {
+	ReceiptID  string `json:"receiptId"`
+	CustomerID string `json:"customerId,omitempty"`
+	OldVersion string `json:"oldVersion"`
+	OldPoints  int64  `json:"oldPoints"`
+	NewPoints  int64  `json:"newPoints"`
+	Difference int64  `json:"difference"`
+}
+
+// Job is a background re-scoring of the stored receipts, the diffs are the report of the receipts that changed
+type Job struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Version is empty when each receipt is scored with the rules it resolves to
+	Version string        `json:"version"`
+	DryRun  bool          `json:"dryRun"`
+	Filter  RescoreFilter `json:"filter"`
+	// Total is the number of receipts to look at, Processed how many have been looked at so far
+	Total     int `json:"total"`
+	Processed int `json:"processed"`
+	// Changed is the number of receipts whose points change, PointsDifference the sum of the changes
+	Changed          int           `json:"changed"`
+	PointsDifference int64         `json:"pointsDifference"`
+	Diffs            []RescoreDiff `json:"diffs"`
+	CreatedAt        time.Time     `json:"createdAt"`
+	FinishedAt       *time.Time    `json:"finishedAt,omitempty"`
+
+	cancel context.CancelFunc
+	// done is closed when the job finishes
+	done chan struct{}
+}
+
+// jobStore keeps the jobs in memory
+type jobStore struct {
+	mutex  sync.RWMutex
+	jobs   map[string]*Job
+	lastID int
+}
+
+// newJobStore creates a store with no jobs
+func newJobStore() *jobStore {
+	return &jobStore{jobs: make(map[string]*Job)}
+}
+
+// Stores the jobs in memory
+var jobs *jobStore = newJobStore()
+
+// snapshot returns a copy of the job that is safe to encode while it runs
+func (s *jobStore) snapshot(job *Job) Job {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	copied := *job
+	copied.Diffs = append([]RescoreDiff{}, job.Diffs...)
+	return copied
+}
+
+// get returns the job with the id
+func (s *jobStore) get(id string) (*Job, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	job, ok := s.jobs[id]
+	return job, ok
+}
+
+// list returns a copy of every job ordered by id
+func (s *jobStore) list() []Job {
+	s.mutex.RLock()
+	list := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, job)
+	}
+	s.mutex.RUnlock()
+	sort.Slice(list, func(a, b int) bool {
+		return idNumber(list[a].ID, "Job") < idNumber(list[b].ID, "Job")
+	})
+	copies := make([]Job, len(list))
+	for i, job := range list {
+		copies[i] = s.snapshot(job)
+	}
+	return copies
+}
+
+// idNumber returns the number after the prefix of an id like "Receipt12"
+func idNumber(id string, prefix string) int {
+	number, _ := strconv.Atoi(strings.TrimPrefix(id, prefix))
+	return number
+}
+
+// validate checks the dates of the filter
+func (filter RescoreFilter) validate() error {
+	for _, date := range []string{filter.FromDate, filter.ToDate} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return errors.New("fromDate and toDate must use the layout 2006-01-02")
+		}
+	}
+	return nil
+}
+
+// matches reports whether the receipt passes the filter
+func (filter RescoreFilter) matches(receipt Receipt) bool {
+	if filter.Retailer != "" && !receipt.sameRetailer(filter.Retailer) {
+		return false
+	}
+	if filter.CustomerID != "" && receipt.CustomerID != filter.CustomerID {
+		return false
+	}
+	if filter.Tenant != "" && receipt.Tenant != filter.Tenant {
+		return false
+	}
+	// Both use the same fixed width layout, so they compare as strings
+	if filter.FromDate != "" && receipt.PurchaseDate < filter.FromDate {
+		return false
+	}
+	if filter.ToDate != "" && receipt.PurchaseDate > filter.ToDate {
+		return false
+	}
+	if filter.RuleVersion != "" && (receipt.Breakdown == nil || receipt.Breakdown.RuleVersion != filter.RuleVersion) {
+		return false
+	}
+	return true
+}
+
+// matchingReceiptIDs returns the ids of the stored receipts that pass the filter, oldest first
+func matchingReceiptIDs(filter RescoreFilter) []string {
+	receiptsMutex.RLock()
+	defer receiptsMutex.RUnlock()
+	ids := []string{}
+	for id, receipt := range receiptsMap {
+		if filter.matches(receipt) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return idNumber(ids[a], "Receipt") < idNumber(ids[b], "Receipt")
+	})
+	return ids
+}
+
+// startRescoreJob creates the job and runs it in the background, rules is nil to resolve the rules of each receipt
+func startRescoreJob(request RescoreJobRequest, rules *RuleSet) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		Status:    jobRunning,
+		Version:   request.Version,
+		DryRun:    request.DryRun,
+		Filter:    request.Filter,
+		Diffs:     []RescoreDiff{},
+		CreatedAt: time.Now().UTC(),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	jobs.mutex.Lock()
+	jobs.lastID += 1
+	job.ID = "Job" + strconv.Itoa(jobs.lastID)
+	jobs.jobs[job.ID] = job
+	jobs.mutex.Unlock()
+
+	go runRescoreJob(ctx, job, rules)
+	return job
+}
+
+// runRescoreJob scores each matching receipt again, recording the ones that change and,
+// unless it is a dry run, storing the new breakdown and adjusting the customer's points
+func runRescoreJob(ctx context.Context, job *Job, rules *RuleSet) {
+	defer close(job.done)
+	ids := matchingReceiptIDs(job.Filter)
+	jobs.mutex.Lock()
+	job.Total = len(ids)
+	jobs.mutex.Unlock()
+
+	status := jobCompleted
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			status = jobCancelled
+			break
+		}
+		if diff, ok := rescoreStoredReceipt(id, rules, job.DryRun); ok {
+			jobs.mutex.Lock()
+			job.Diffs = append(job.Diffs, diff)
+			job.Changed += 1
+			job.PointsDifference += diff.Difference
+			jobs.mutex.Unlock()
+		}
+		jobs.mutex.Lock()
+		job.Processed += 1
+		jobs.mutex.Unlock()
+	}
+
+	finished := time.Now().UTC()
+	jobs.mutex.Lock()
+	job.Status = status
+	job.FinishedAt = &finished
+	jobs.mutex.Unlock()
+	job.cancel()
+	slog.Info("rescore job finished", slog.String("jobId", job.ID), slog.String("status", status),
+		slog.Int("processed", job.Processed), slog.Int("changed", job.Changed), slog.Bool("dryRun", job.DryRun))
+}
+
+// rescoreStoredReceipt scores the receipt with the rules, or the rules it resolves to when they are nil,
+// and returns the diff if its points change
+func rescoreStoredReceipt(id string, rules *RuleSet, dryRun bool) (RescoreDiff, bool) {
+	for {
+		receiptsMutex.RLock()
+		receipt, ok := receiptsMap[id]
+		receiptsMutex.RUnlock()
+		if !ok {
+			// Deleted since the job started
+			return RescoreDiff{}, false
+		}
+
+		// Custom rules can take a while, so the receipt is scored without holding the lock
+		recorded := receipt.Breakdown
+		if recorded == nil {
+			recorded = baseBreakdown(receipt)
+		}
+		var rescored *PointsBreakdown
+		if rules != nil {
+			rescored = rescoreReceipt(receipt, *rules)
+		} else {
+			resolved, _ := ruleSets.resolve(receipt.Tenant, receipt.canonicalRetailer())
+			rescored = rescoreReceipt(receipt, resolved)
+		}
+		diff := RescoreDiff{
+			ReceiptID:  id,
+			CustomerID: receipt.CustomerID,
+			OldVersion: recorded.RuleVersion,
+			OldPoints:  recorded.Total,
+			NewPoints:  rescored.Total,
+			Difference: rescored.Total - recorded.Total,
+		}
+		if dryRun {
+			return diff, diff.Difference != 0
+		}
+
+		receiptsMutex.Lock()
+		stored, ok := receiptsMap[id]
+		if !ok || stored.Breakdown != receipt.Breakdown {
+			// Corrected, re-scored or deleted in the meantime, score what is stored now
+			receiptsMutex.Unlock()
+			continue
+		}
+		// The receipts lock is held until the ledger is adjusted so a correction can not come in between
+		receipt.Breakdown = rescored
+		receiptsMap[id] = receipt
+		ledger.adjustReceipt(receipt, id, diff.Difference)
+		receiptsMutex.Unlock()
+		return diff, diff.Difference != 0
+	}
+}
+
+// createRescoreJob starts a re-scoring job
+func createRescoreJob(c *gin.Context) {
+	var request RescoreJobRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusBadRequest, "The job is invalid.")
+		return
+	}
+	if err := request.Filter.validate(); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusBadRequest, "The job is invalid: "+err.Error()+".")
+		return
+	}
+	// Admins of a tenant only re-score the receipts of their tenant
+	if tenant := requestTenant(c); tenant != "" {
+		request.Filter.Tenant = tenant
+	}
+	var rules *RuleSet
+	if request.Version != "" {
+		version, ok := ruleSets.get(request.Version)
+		if !ok || !version.visibleTo(request.Filter.Tenant) {
+			c.String(http.StatusBadRequest, "The job is invalid: unknown rule set version "+request.Version+".")
+			return
+		}
+		if version.Tenant != "" && request.Filter.Tenant != version.Tenant {
+			c.String(http.StatusBadRequest, "The job is invalid: rule set "+request.Version+" is only for tenant "+version.Tenant+".")
+			return
+		}
+		rules = &version
+	}
+
+	job := startRescoreJob(request, rules)
+	c.JSON(http.StatusAccepted, jobs.snapshot(job))
+}
+
+// listJobs returns every job, or the jobs of the tenant of the request
+func listJobs(c *gin.Context) {
+	list := []Job{}
+	for _, job := range jobs.list() {
+		if tenant := requestTenant(c); tenant == "" || job.Filter.Tenant == tenant {
+			list = append(list, job)
+		}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// findJob returns the job if it exists and the request can see it, the jobs of other tenants are not found
+func findJob(c *gin.Context) (*Job, bool) {
+	job, ok := jobs.get(c.Param("id"))
+	if tenant := requestTenant(c); ok && tenant != "" && job.Filter.Tenant != tenant {
+		return nil, false
+	}
+	return job, ok
+}
+
+// getJob returns the progress of a job and the diffs found so far
+func getJob(c *gin.Context) {
+	job, ok := findJob(c)
+	if !ok {
+		c.String(http.StatusNotFound, "No job found for that ID.")
+		return
+	}
+	c.JSON(http.StatusOK, jobs.snapshot(job))
+}
+
+// cancelJob stops a running job, the receipts it already re-scored keep their new points
+func cancelJob(c *gin.Context) {
+	job, ok := findJob(c)
+	if !ok {
+		c.String(http.StatusNotFound, "No job found for that ID.")
+		return
+	}
+	if status := jobs.snapshot(job).Status; status != jobRunning {
+		c.String(http.StatusConflict, "The job is already "+status+".")
+		return
+	}
+	job.cancel()
+	<-job.done
+	c.JSON(http.StatusOK, jobs.snapshot(job))
+}
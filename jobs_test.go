@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRescoreRouter stores validReceipt2 for Customer1 and validReceipt1 without a customer under rule set 1,
+// version 2 of the rules gives them 60 and 10 more points
+func newRescoreRouter(t *testing.T) *gin.Engine {
+	cfg := defaultConfig()
+	cfg.RulesFile = writeRulesFile(t, "1")
+	router := newTestRouter(t, cfg)
+	serveJSON(router, "POST", "/customers", CreateCustomerRequest{Name: "Ada"})
+	receipt := validReceipt2
+	receipt.CustomerID = "Customer1"
+	serveJSON(router, "POST", "/receipts/process", receipt)
+	serveJSON(router, "POST", "/receipts/process", validReceipt1)
+	return router
+}
+
+// writeRetailerOverrideFile writes rules where version 2 gives 500 points for a round total to M&M Corner Market,
+// validReceipt2 earns 559 points under it
+func writeRetailerOverrideFile(t *testing.T) string {
+	data := `{"active": "1", "ruleSets": [{"version": "2", "roundDollarPoints": 500}],
+		"overrides": [{"retailer": "M&M Corner Market", "version": "2"}]}`
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runJob starts the job and waits for it to finish
+func runJob(t *testing.T, router *gin.Engine, request RescoreJobRequest) Job {
+	w := serveJSON(router, "POST", "/jobs/rescore", request)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var job Job
+	json.Unmarshal(w.Body.Bytes(), &job)
+	started, _ := jobs.get(job.ID)
+	<-started.done
+	json.Unmarshal(serveJSON(router, "GET", "/jobs/"+job.ID, nil).Body.Bytes(), &job)
+	return job
+}
+
+// TestRescoreJobDryRun
+func TestRescoreJobDryRun(t *testing.T) {
+	setup()
+	router := newRescoreRouter(t)
+
+	job := runJob(t, router, RescoreJobRequest{Version: "2", DryRun: true})
+	assert.Equal(t, jobCompleted, job.Status)
+	assert.Equal(t, 2, job.Total)
+	assert.Equal(t, 2, job.Processed)
+	assert.Equal(t, 2, job.Changed)
+	assert.Equal(t, int64(70), job.PointsDifference)
+	assert.Equal(t, []RescoreDiff{
+		{ReceiptID: "Receipt1", CustomerID: "Customer1", OldVersion: "1", OldPoints: 109, NewPoints: 169, Difference: 60},
+		{ReceiptID: "Receipt2", OldVersion: "1", OldPoints: 28, NewPoints: 38, Difference: 10},
+	}, job.Diffs)
+	assert.NotNil(t, job.FinishedAt)
+
+	// Nothing was changed
+	expectedResponse, _ := json.Marshal(PointsGeneratedResponse{Points: 109, RuleVersion: "1"})
+	assert.Equal(t, string(expectedResponse), serveJSON(router, "GET", "/receipts/Receipt1/points", nil).Body.String())
+	assert.Equal(t, int64(109), ledger.balance("Customer1"))
+
+	teardown()
+}
+
+// TestRescoreJobAppliesWithLedgerAdjustments
+func TestRescoreJobAppliesWithLedgerAdjustments(t *testing.T) {
+	setup()
+	router := newRescoreRouter(t)
+
+	job := runJob(t, router, RescoreJobRequest{Version: "2", Filter: RescoreFilter{CustomerID: "Customer1"}})
+	assert.Equal(t, 1, job.Total)
+	assert.Equal(t, 1, job.Changed)
+
+	expectedResponse, _ := json.Marshal(PointsGeneratedResponse{Points: 169, RuleVersion: "2"})
+	assert.Equal(t, string(expectedResponse), serveJSON(router, "GET", "/receipts/Receipt1/points", nil).Body.String())
+	assert.Equal(t, int64(169), ledger.balance("Customer1"))
+	entries := ledger.entriesFor("Customer1")
+	assert.Equal(t, entryAdjustment, entries[len(entries)-1].Type)
+	assert.Equal(t, int64(60), entries[len(entries)-1].Points)
+	assert.Equal(t, "Receipt1", entries[len(entries)-1].ReceiptID)
+
+	// Only the receipt still on version 1 is looked at
+	job = runJob(t, router, RescoreJobRequest{Version: "2", Filter: RescoreFilter{RuleVersion: "1"}})
+	assert.Equal(t, 1, job.Total)
+	assert.Equal(t, "Receipt2", job.Diffs[0].ReceiptID)
+
+	// Deleting the receipt takes back the adjusted points too
+	serveJSON(router, "DELETE", "/receipts/Receipt1", nil)
+	assert.Equal(t, int64(0), ledger.balance("Customer1"))
+
+	var list []Job
+	json.Unmarshal(serveJSON(router, "GET", "/jobs", nil).Body.Bytes(), &list)
+	assert.Len(t, list, 2)
+
+	teardown()
+}
+
+// TestRescoreJobCancel
+func TestRescoreJobCancel(t *testing.T) {
+	setup()
+	router := newRescoreRouter(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	job := &Job{ID: "Job1", Status: jobRunning, Diffs: []RescoreDiff{}, cancel: cancel, done: make(chan struct{})}
+	jobs.jobs[job.ID] = job
+	rules, _ := ruleSets.get("2")
+	runRescoreJob(ctx, job, &rules)
+	assert.Equal(t, jobCancelled, job.Status)
+	assert.Equal(t, 2, job.Total)
+	assert.Equal(t, 0, job.Processed)
+
+	w := serveJSON(router, "POST", "/jobs/Job1/cancel", nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "The job is already cancelled.", w.Body.String())
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "POST", "/jobs/Job9/cancel", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "GET", "/jobs/Job9", nil).Code)
+
+	teardown()
+}
+
+// TestRescoreJobInvalid
+func TestRescoreJobInvalid(t *testing.T) {
+	setup()
+	router := newRescoreRouter(t)
+
+	w := serveJSON(router, "POST", "/jobs/rescore", RescoreJobRequest{Version: "9"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The job is invalid: unknown rule set version 9.", w.Body.String())
+	w = serveJSON(router, "POST", "/jobs/rescore", RescoreJobRequest{Filter: RescoreFilter{FromDate: "March"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The job is invalid: fromDate and toDate must use the layout 2006-01-02.", w.Body.String())
+
+	teardown()
+}
+
+// TestRescoreJobResolvesOverrides
+func TestRescoreJobResolvesOverrides(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.RulesFile = writeRetailerOverrideFile(t)
+	router := newTestRouter(t, cfg)
+	serveJSON(router, "POST", "/customers", CreateCustomerRequest{Name: "Ada"})
+	receipt := validReceipt2
+	receipt.CustomerID = "Customer1"
+	serveJSON(router, "POST", "/receipts/process", receipt)
+	serveJSON(router, "POST", "/receipts/process", validReceipt1)
+	assert.Equal(t, int64(559), ledger.balance("Customer1"))
+
+	// Without a version each receipt keeps the rules of its override, so nothing changes
+	job := runJob(t, router, RescoreJobRequest{})
+	assert.Equal(t, "", job.Version)
+	assert.Equal(t, 2, job.Total)
+	assert.Equal(t, 0, job.Changed)
+	assert.Equal(t, "2", receiptsMap["Receipt1"].Breakdown.RuleVersion)
+	assert.Equal(t, int64(559), ledger.balance("Customer1"))
+
+	// A version is used for every receipt
+	job = runJob(t, router, RescoreJobRequest{Version: "1", DryRun: true})
+	assert.Equal(t, []RescoreDiff{{ReceiptID: "Receipt1", CustomerID: "Customer1", OldVersion: "2", OldPoints: 559, NewPoints: 109, Difference: -450}}, job.Diffs)
+
+	teardown()
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long to wait before fetching a JWKS URL again for an unknown key id
+const jwksRefetchInterval = time.Minute
+
+// jwk is a single public key from a JSON Web Key Set, only RSA and P-256 EC keys are supported
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwtHeader is the decoded first part of a token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims are the registered claims the service checks plus the scopes and the tenant
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	Scope     string   `json:"scope"`
+	Scp       []string `json:"scp"`
+	Tenant    string   `json:"tenant"`
+}
+
+// audience accepts the aud claim as either a single string or a list
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// scopes returns the scopes from either the space separated scope claim or the scp list
+func (claims jwtClaims) scopes() []string {
+	scopes := strings.Fields(claims.Scope)
+	return append(scopes, claims.Scp...)
+}
+
+// jwtVerifier checks bearer tokens against the keys from a JWKS file or URL
+type jwtVerifier struct {
+	cfg JWTConfig
+	now func() time.Time
+
+	mutex sync.RWMutex
+	keys  map[string]crypto.PublicKey
+	// lastFetched is when the key set was last fetched, whether or not the fetch worked
+	lastFetched time.Time
+}
+
+// newJWTVerifier loads the key set named in the config
+func newJWTVerifier(cfg JWTConfig) (*jwtVerifier, error) {
+	verifier := &jwtVerifier{cfg: cfg, now: time.Now}
+	if err := verifier.loadKeys(); err != nil {
+		return nil, err
+	}
+	return verifier, nil
+}
+
+// loadKeys reads the key set from disk, or fetches it when the source is a URL
+func (v *jwtVerifier) loadKeys() error {
+	var data []byte
+	var err error
+	if strings.HasPrefix(v.cfg.JWKS, "https://") || strings.HasPrefix(v.cfg.JWKS, "http://") {
+		data, err = fetchJWKS(v.cfg.JWKS)
+	} else {
+		data, err = os.ReadFile(v.cfg.JWKS)
+	}
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	v.mutex.Lock()
+	v.keys = keys
+	v.lastFetched = v.now()
+	v.mutex.Unlock()
+	return nil
+}
+
+// fetchJWKS downloads the key set from the identity provider
+func fetchJWKS(url string) ([]byte, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// parseJWKS decodes the public keys of a JSON Web Key Set by key id
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, key := range set.Keys {
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key.Kid, err)
+		}
+		keys[key.Kid] = publicKey
+	}
+	return keys, nil
+}
+
+// publicKey converts the JWK into an RSA or ECDSA public key
+func (key jwk) publicKey() (crypto.PublicKey, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeBigInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if key.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", key.Crv)
+		}
+		x, err := decodeBigInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !publicKey.Curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return publicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", key.Kty)
+	}
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// key returns the public key for the id, fetching a URL key set again if the id is unknown
+func (v *jwtVerifier) key(kid string) (crypto.PublicKey, bool) {
+	v.mutex.RLock()
+	key, ok := v.keys[kid]
+	lastFetched := v.lastFetched
+	v.mutex.RUnlock()
+	if ok {
+		return key, true
+	}
+	// The identity provider may have rotated its keys
+	if !strings.HasPrefix(v.cfg.JWKS, "http") || v.now().Sub(lastFetched) <= jwksRefetchInterval {
+		return nil, false
+	}
+	// Claim the attempt before fetching, so unknown ids and a failing provider only cause one fetch per interval
+	v.mutex.Lock()
+	if v.now().Sub(v.lastFetched) <= jwksRefetchInterval {
+		v.mutex.Unlock()
+		return nil, false
+	}
+	v.lastFetched = v.now()
+	v.mutex.Unlock()
+	if err := v.loadKeys(); err == nil {
+		v.mutex.RLock()
+		key, ok = v.keys[kid]
+		v.mutex.RUnlock()
+	}
+	return key, ok
+}
+
+// verify checks the signature and the time, issuer and audience claims of the token
+func (v *jwtVerifier) verify(token string) (jwtClaims, error) {
+	var claims jwtClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, err
+	}
+	key, ok := v.key(header.Kid)
+	if !ok {
+		return claims, fmt.Errorf("unknown key id %q", header.Kid)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errors.New("malformed signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	// The algorithm has to match the type of the key so an RSA key can not be used as an HMAC secret
+	switch header.Alg {
+	case "RS256":
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) != nil {
+			return claims, errors.New("invalid signature")
+		}
+	case "ES256":
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return claims, errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(publicKey, digest[:], r, s) {
+			return claims, errors.New("invalid signature")
+		}
+	default:
+		return claims, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, err
+	}
+	now := v.now().Unix()
+	if claims.ExpiresAt == 0 || now >= claims.ExpiresAt {
+		return claims, errors.New("token is expired")
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return claims, errors.New("token is not valid yet")
+	}
+	if v.cfg.Issuer != "" && claims.Issuer != v.cfg.Issuer {
+		return claims, errors.New("unexpected issuer")
+	}
+	if v.cfg.Audience != "" && !slices.Contains(claims.Audience, v.cfg.Audience) {
+		return claims, errors.New("unexpected audience")
+	}
+	if claims.Subject == "" {
+		return claims, errors.New("token has no subject")
+	}
+	return claims, nil
+}
+
+// decodeSegment decodes a base64url JSON part of the token into v
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
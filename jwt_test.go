@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// testKeySet is a local RSA and EC key pair published as a JWKS file for the tests
+type testKeySet struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	path   string
+}
+
+// newTestKeySet generates the keys and writes the public halves to a JWKS file
+func newTestKeySet(t *testing.T) testKeySet {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	set := map[string][]jwk{"keys": {
+		{Kty: "RSA", Kid: "rsa-1", Alg: "RS256", N: encode(rsaKey.N.Bytes()), E: encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{Kty: "EC", Kid: "ec-1", Alg: "ES256", Crv: "P-256", X: encode(ecKey.X.FillBytes(make([]byte, 32))), Y: encode(ecKey.Y.FillBytes(make([]byte, 32)))},
+	}}
+	data, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return testKeySet{rsaKey: rsaKey, ecKey: ecKey, path: path}
+}
+
+// sign creates a token for the claims with the given algorithm and key id
+func (keys testKeySet) sign(t *testing.T, alg string, kid string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	var signature []byte
+	switch alg {
+	case "RS256":
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, keys.rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, keys.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims returns claims for subject that expire in an hour
+func validClaims(subject string, scope string) map[string]any {
+	return map[string]any{
+		"sub":   subject,
+		"iss":   "https://idp.example.com",
+		"aud":   "receipt-processor",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": scope,
+	}
+}
+
+// newJWTRouter creates a router that only accepts tokens signed by keys
+func newJWTRouter(t *testing.T, keys testKeySet) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	cfg := defaultConfig()
+	cfg.Auth.JWT = JWTConfig{JWKS: keys.path, Issuer: "https://idp.example.com", Audience: "receipt-processor"}
+	var buf bytes.Buffer
+	router, err := setupRouter(cfg, newTestLogger(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+// TestJWTVerifyRS256AndES256
+func TestJWTVerifyRS256AndES256(t *testing.T) {
+	keys := newTestKeySet(t)
+	verifier, err := newJWTVerifier(JWTConfig{JWKS: keys.path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := verifier.verify(keys.sign(t, "RS256", "rsa-1", validClaims("user-1", "receipts:read receipts:write")))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+	assert.Equal(t, []string{"receipts:read", "receipts:write"}, claims.scopes())
+
+	claims, err = verifier.verify(keys.sign(t, "ES256", "ec-1", validClaims("user-2", "admin")))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-2", claims.Subject)
+}
+
+// TestJWTVerifyRejectsInvalidTokens
+func TestJWTVerifyRejectsInvalidTokens(t *testing.T) {
+	keys := newTestKeySet(t)
+	verifier, _ := newJWTVerifier(JWTConfig{JWKS: keys.path, Issuer: "https://idp.example.com", Audience: "receipt-processor"})
+
+	expired := validClaims("user-1", "admin")
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	wrongIssuer := validClaims("user-1", "admin")
+	wrongIssuer["iss"] = "https://evil.example.com"
+	wrongAudience := validClaims("user-1", "admin")
+	wrongAudience["aud"] = []string{"another-service"}
+
+	tokens := map[string]string{
+		"expired":        keys.sign(t, "RS256", "rsa-1", expired),
+		"wrong issuer":   keys.sign(t, "RS256", "rsa-1", wrongIssuer),
+		"wrong audience": keys.sign(t, "RS256", "rsa-1", wrongAudience),
+		"unknown kid":    keys.sign(t, "RS256", "rsa-2", validClaims("user-1", "admin")),
+		"alg mismatch":   keys.sign(t, "ES256", "rsa-1", validClaims("user-1", "admin")),
+		"malformed":      "not.a-token",
+	}
+	for name, token := range tokens {
+		if _, err := verifier.verify(token); err == nil {
+			t.Fatalf("verify(%s) returned no error", name)
+		}
+	}
+
+	// Changing the payload invalidates the signature
+	token := strings.Split(keys.sign(t, "RS256", "rsa-1", validClaims("user-1", "receipts:read")), ".")
+	other := strings.Split(keys.sign(t, "RS256", "rsa-1", validClaims("user-2", "admin")), ".")
+	_, err := verifier.verify(token[0] + "." + other[1] + "." + token[2])
+	assert.Error(t, err)
+}
+
+// TestJWTRefetchOnce
+func TestJWTRefetchOnce(t *testing.T) {
+	keys := newTestKeySet(t)
+	data, _ := os.ReadFile(keys.path)
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only the first fetch works, the provider is down after that
+		if fetches.Add(1) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+	verifier, err := newJWTVerifier(JWTConfig{JWKS: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Many tokens with an unknown key id at once fetch the key set once
+	now := time.Now().Add(2 * jwksRefetchInterval)
+	verifier.now = func() time.Time { return now }
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			verifier.key("rsa-2")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), fetches.Load())
+
+	// The failed fetch counts as the last one
+	now = now.Add(jwksRefetchInterval / 2)
+	_, ok := verifier.key("rsa-2")
+	assert.False(t, ok)
+	assert.Equal(t, int32(2), fetches.Load())
+	now = now.Add(jwksRefetchInterval)
+	verifier.key("rsa-2")
+	assert.Equal(t, int32(3), fetches.Load())
+	_, ok = verifier.key("rsa-1")
+	assert.True(t, ok)
+}
+
+// TestJWTAuthScopesAndOwner
+func TestJWTAuthScopesAndOwner(t *testing.T) {
+	setup()
+	keys := newTestKeySet(t)
+	router := newJWTRouter(t, keys)
+	jsonbytes, _ := json.Marshal(validReceipt1)
+
+	// No token
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(jsonbytes))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// A read only token can not submit receipts
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(jsonbytes))
+	req.Header.Set("Authorization", "Bearer "+keys.sign(t, "RS256", "rsa-1", validClaims("user-1", "receipts:read")))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// The subject becomes the owner of the receipt
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(jsonbytes))
+	req.Header.Set("Authorization", "Bearer "+keys.sign(t, "ES256", "ec-1", validClaims("user-1", "receipts:write")))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "user-1", receiptsMap["Receipt1"].Owner)
+
+	// A write only token can not read points
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/receipts/Receipt1/points", nil)
+	req.Header.Set("Authorization", "Bearer "+keys.sign(t, "RS256", "rsa-1", validClaims("user-1", "receipts:write")))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Admin is allowed on every route but still only sees its own receipts
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/receipts/Receipt1/points", nil)
+	req.Header.Set("Authorization", "Bearer "+keys.sign(t, "RS256", "rsa-1", validClaims("user-2", "admin")))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/receipts/Receipt1/points", nil)
+	req.Header.Set("Authorization", "Bearer "+keys.sign(t, "RS256", "rsa-1", validClaims("user-1", "receipts:read")))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	teardown()
+}
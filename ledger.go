@@ -0,0 +1,177 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Types of ledger entries
+const (
+	// entryEarn credits the points awarded for a receipt
+	entryEarn = "earn"
+	// entryReversal takes back the points of a receipt that was deleted or corrected
+	entryReversal = "reversal"
+	// entryHold reserves points for a redemption
+	entryHold = "hold"
+	// entryCapture marks the held points as spent, it does not change the balance
+	entryCapture = "capture"
+	// entryRelease gives back the held points of a redemption that was not completed
+	entryRelease = "release"
+	// entryExpire takes away the points of an earn entry that were not spent before they expired
+	entryExpire = "expire"
+	// entryAdjustment changes the points of a receipt that was scored again with other rules
+	entryAdjustment = "adjustment"
+)
+
+// LedgerEntry is one change to a customer's points, entries are never changed or removed once appended
+type LedgerEntry struct {
+	ID         string `json:"id"`
+	CustomerID string `json:"customerId"`
+	Type       string `json:"type"`
+	Points     int64  `json:"points"`
+	ReceiptID  string `json:"receiptId,omitempty"`
+	HoldID     string `json:"holdId,omitempty"`
+	// ExpiresAt is set on earn entries when an expiry policy is configured
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// SourceEntryID is the earn entry an expire entry takes the points from
+	SourceEntryID string    `json:"sourceEntryId,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// pointsLedger is the append-only list of ledger entries, balances are always derived from it
+type pointsLedger struct {
+	mutex      sync.RWMutex
+	entries    []LedgerEntry
+	byCustomer map[string][]int
+	holds      map[string]*Redemption
+	now        func() time.Time
+}
+
+// newPointsLedger creates an empty ledger
+func newPointsLedger() *pointsLedger {
+	return &pointsLedger{byCustomer: make(map[string][]int), holds: make(map[string]*Redemption), now: time.Now}
+}
+
+// Stores the ledger in memory
+var ledger *pointsLedger = newPointsLedger()
+
+// append assigns the entry an id and time and adds it to the end of the ledger
+func (l *pointsLedger) append(entry LedgerEntry) LedgerEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.appendLocked(entry)
+}
+
+// appendLocked is append for callers that already hold the mutex
+func (l *pointsLedger) appendLocked(entry LedgerEntry) LedgerEntry {
+	entry.ID = "Entry" + strconv.Itoa(len(l.entries)+1)
+	entry.CreatedAt = l.now().UTC()
+	l.byCustomer[entry.CustomerID] = append(l.byCustomer[entry.CustomerID], len(l.entries))
+	l.entries = append(l.entries, entry)
+	return entry
+}
+
+// entriesFor returns a copy of the customer's entries, oldest first
+func (l *pointsLedger) entriesFor(customerID string) []LedgerEntry {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	entries := make([]LedgerEntry, 0, len(l.byCustomer[customerID]))
+	for _, i := range l.byCustomer[customerID] {
+		entries = append(entries, l.entries[i])
+	}
+	return entries
+}
+
+// balance adds up the customer's entries, points on hold are already taken out
+func (l *pointsLedger) balance(customerID string) int64 {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return l.balanceLocked(customerID)
+}
+
+// balanceLocked is balance for callers that already hold the mutex
+func (l *pointsLedger) balanceLocked(customerID string) int64 {
+	var balance int64 = 0
+	for _, i := range l.byCustomer[customerID] {
+		balance += l.entries[i].Points
+	}
+	return balance
+}
+
+// creditReceipt adds an earn entry for the receipt's points if it was submitted against a customer
+func (l *pointsLedger) creditReceipt(receipt Receipt, receiptID string) {
+	if receipt.CustomerID == "" {
+		return
+	}
+	l.append(LedgerEntry{
+		CustomerID: receipt.CustomerID,
+		Type:       entryEarn,
+		Points:     receiptPoints(receipt),
+		ReceiptID:  receiptID,
+		ExpiresAt:  appConfig.Expiry.expiresAt(receipt.PurchaseDate),
+	})
+	tiers.update(receipt.CustomerID)
+}
+
+// adjustReceipt adds an adjustment entry for the change in the receipt's points after it was scored again
+func (l *pointsLedger) adjustReceipt(receipt Receipt, receiptID string, difference int64) {
+	if receipt.CustomerID == "" || difference == 0 {
+		return
+	}
+	entry := LedgerEntry{
+		CustomerID: receipt.CustomerID,
+		Type:       entryAdjustment,
+		Points:     difference,
+		ReceiptID:  receiptID,
+	}
+	// Extra points expire with the rest of the receipt's points
+	if difference > 0 {
+		entry.ExpiresAt = appConfig.Expiry.expiresAt(receipt.PurchaseDate)
+	}
+	l.append(entry)
+	tiers.update(receipt.CustomerID)
+}
+
+// reverseReceipt takes back whatever the receipt has earned the customer so far, points that already expired are not taken twice
+func (l *pointsLedger) reverseReceipt(customerID string, receiptID string) {
+	if customerID == "" {
+		return
+	}
+	l.mutex.Lock()
+	var earned int64 = 0
+	for _, i := range l.byCustomer[customerID] {
+		if l.entries[i].ReceiptID == receiptID {
+			earned += l.entries[i].Points
+		}
+	}
+	if earned != 0 {
+		l.appendLocked(LedgerEntry{
+			CustomerID: customerID,
+			Type:       entryReversal,
+			Points:     -earned,
+			ReceiptID:  receiptID,
+		})
+	}
+	l.mutex.Unlock()
+
+	tiers.update(customerID)
+}
+
+// earnedSince adds up the points the customer earned from receipts since the given time, net of reversals
+func (l *pointsLedger) earnedSince(customerID string, since time.Time) int64 {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	var earned int64 = 0
+	for _, i := range l.byCustomer[customerID] {
+		entry := l.entries[i]
+		if (entry.Type == entryEarn || entry.Type == entryReversal || entry.Type == entryAdjustment) && !entry.CreatedAt.Before(since) {
+			earned += entry.Points
+		}
+	}
+	return earned
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLedgerBalanceIsSumOfEntries
+func TestLedgerBalanceIsSumOfEntries(t *testing.T) {
+	l := newPointsLedger()
+	l.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+
+	first := l.append(LedgerEntry{CustomerID: "Customer1", Type: entryEarn, Points: 28, ReceiptID: "Receipt1"})
+	l.append(LedgerEntry{CustomerID: "Customer2", Type: entryEarn, Points: 50, ReceiptID: "Receipt2"})
+	l.append(LedgerEntry{CustomerID: "Customer1", Type: entryEarn, Points: 109, ReceiptID: "Receipt3"})
+
+	assert.Equal(t, "Entry1", first.ID)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), first.CreatedAt)
+	assert.Equal(t, int64(137), l.balance("Customer1"))
+	assert.Equal(t, int64(50), l.balance("Customer2"))
+	assert.Equal(t, int64(0), l.balance("Customer3"))
+
+	entries := l.entriesFor("Customer1")
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "Receipt1", entries[0].ReceiptID)
+	assert.Equal(t, "Receipt3", entries[1].ReceiptID)
+
+	// The returned entries are a copy of the ledger
+	entries[0].Points = 0
+	assert.Equal(t, int64(137), l.balance("Customer1"))
+}
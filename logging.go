@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Header used to pass the request id between services
+const requestIDHeader = "X-Request-ID"
+
+// Request ids from callers are only reused when they look like this, so they can not forge log lines
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Keys used to pass values from the handlers to the logging middleware
+const (
+	requestIDKey       = "requestId"
+	receiptIDKey       = "receiptId"
+	validationErrorKey = "validationError"
+	authErrorKey       = "authError"
+	receiptLogKey      = "receiptLog"
+)
+
+// newLogger creates a JSON logger writing to stdout at the configured level
+func newLogger(cfg LoggingConfig) *slog.Logger {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: parseLogLevel(cfg.Level)})
+	return slog.New(handler)
+}
+
+// parseLogLevel turns the level name from the config into a slog.Level, defaulting to info
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// requestLogger assigns or propagates the X-Request-ID and logs one line for every request
+func requestLogger(logger *slog.Logger, cfg LoggingConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		// Reuse the caller's request id if there is one so requests can be followed across services
+		requestID := c.GetHeader(requestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		c.Set(requestIDKey, requestID)
+		c.Header(requestIDHeader, requestID)
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		attrs := []slog.Attr{
+			slog.String("requestId", requestID),
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.Int("status", c.Writer.Status()),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("clientIp", c.ClientIP()),
+		}
+		if clientID := c.GetString(clientIDKey); clientID != "" {
+			attrs = append(attrs, slog.String("clientId", clientID))
+		}
+		if tenant := c.GetString(tenantKey); tenant != "" {
+			attrs = append(attrs, slog.String("tenant", tenant))
+		}
+		if receiptID := c.GetString(receiptIDKey); receiptID != "" {
+			attrs = append(attrs, slog.String("receiptId", receiptID))
+		}
+		if reason := c.GetString(validationErrorKey); reason != "" {
+			attrs = append(attrs, slog.String("validationError", reason))
+		}
+		if reason := c.GetString(authErrorKey); reason != "" {
+			attrs = append(attrs, slog.String("authError", reason))
+		}
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if c.Writer.Status() >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+
+		// The receipt contents are only logged when debugging
+		if receipt, ok := c.Get(receiptLogKey); ok {
+			logger.LogAttrs(c.Request.Context(), slog.LevelDebug, "receipt",
+				slog.String("requestId", requestID),
+				slog.Any("receipt", receiptLogValue(receipt.(Receipt), cfg.RedactPII)))
+		}
+	}
+}
+
+// newRequestID creates a random 16 byte hex id
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// Fall back to the clock if the random source fails
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// receiptLogValue builds the log group for a receipt, hiding the free text fields when redact is set
+func receiptLogValue(receipt Receipt, redact bool) slog.Value {
+	descriptions := make([]string, len(receipt.Items))
+	for i, item := range receipt.Items {
+		descriptions[i] = redactText(item.ShortDescription, redact)
+	}
+	return slog.GroupValue(
+		slog.String("retailer", redactText(receipt.Retailer, redact)),
+		slog.String("purchaseDate", receipt.PurchaseDate),
+		slog.String("purchaseTime", receipt.PurchaseTime),
+		slog.String("total", receipt.Total),
+		slog.Int("itemCount", len(receipt.Items)),
+		slog.Any("items", descriptions),
+	)
+}
+
+// redactText replaces the text with a placeholder when redact is set
+func redactText(text string, redact bool) string {
+	if !redact {
+		return text
+	}
+	return "[REDACTED]"
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestLogger creates a debug level JSON logger that writes into buf
+func newTestLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// decodeLogLines splits the JSON log output into one map per line
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+// TestRequestLoggerGeneratesRequestID
+func TestRequestLoggerGeneratesRequestID(t *testing.T) {
+	setup()
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	router, _ := setupRouter(defaultConfig(), newTestLogger(&buf))
+
+	w := httptest.NewRecorder()
+	jsonbytes, _ := json.Marshal(validReceipt1)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(jsonbytes))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	requestID := w.Header().Get(requestIDHeader)
+	assert.Len(t, requestID, 32)
+
+	lines := decodeLogLines(t, &buf)
+	assert.Equal(t, "request", lines[0]["msg"])
+	assert.Equal(t, requestID, lines[0]["requestId"])
+	assert.Equal(t, "/receipts/process", lines[0]["route"])
+	assert.EqualValues(t, http.StatusOK, lines[0]["status"])
+	assert.Equal(t, "Receipt1", lines[0]["receiptId"])
+	assert.Contains(t, lines[0], "latency")
+
+	teardown()
+}
+
+// TestRequestLoggerPropagatesRequestID
+func TestRequestLoggerPropagatesRequestID(t *testing.T) {
+	setup()
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	router, _ := setupRouter(defaultConfig(), newTestLogger(&buf))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/receipts/Receipt10/points", nil)
+	req.Header.Set(requestIDHeader, "abc-123")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "abc-123", w.Header().Get(requestIDHeader))
+	lines := decodeLogLines(t, &buf)
+	assert.Equal(t, "abc-123", lines[0]["requestId"])
+	assert.Equal(t, "/receipts/:id/points", lines[0]["route"])
+	assert.Equal(t, "WARN", lines[0]["level"])
+
+	// Ids that are too long or have other characters are replaced
+	for _, id := range []string{strings.Repeat("a", 129), "abc\n{\"level\":\"ERROR\"}", "abc 123"} {
+		buf.Reset()
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/receipts/Receipt10/points", nil)
+		req.Header.Set(requestIDHeader, id)
+		router.ServeHTTP(w, req)
+		assert.NotEqual(t, id, w.Header().Get(requestIDHeader))
+		assert.Len(t, w.Header().Get(requestIDHeader), 32)
+		assert.Equal(t, w.Header().Get(requestIDHeader), decodeLogLines(t, &buf)[0]["requestId"])
+	}
+
+	teardown()
+}
+
+// TestRequestLoggerValidationReasonAndRedaction
+func TestRequestLoggerValidationReasonAndRedaction(t *testing.T) {
+	setup()
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	router, _ := setupRouter(defaultConfig(), newTestLogger(&buf))
+
+	w := httptest.NewRecorder()
+	jsonbytes, _ := json.Marshal(receiptInvalidRetailer)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(jsonbytes))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	lines := decodeLogLines(t, &buf)
+	assert.Contains(t, lines[0]["validationError"], "Retailer")
+	assert.NotContains(t, buf.String(), "Tar.get")
+	assert.NotContains(t, buf.String(), "Pepsi")
+	assert.Contains(t, buf.String(), "[REDACTED]")
+
+	teardown()
+}
+
+// TestReceiptLogValueWithoutRedaction
+func TestReceiptLogValueWithoutRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newTestLogger(&buf)
+	logger.Debug("receipt", slog.Any("receipt", receiptLogValue(validReceipt2, false)))
+	assert.Contains(t, buf.String(), "M&M Corner Market")
+	assert.Contains(t, buf.String(), "Gatorade")
+}
+
+// TestParseLogLevel
+func TestParseLogLevel(t *testing.T) {
+	assert.Equal(t, slog.LevelDebug, parseLogLevel("DEBUG"))
+	assert.Equal(t, slog.LevelWarn, parseLogLevel("warn"))
+	assert.Equal(t, slog.LevelError, parseLogLevel("error"))
+	assert.Equal(t, slog.LevelInfo, parseLogLevel(""))
+}
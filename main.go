@@ -1,28 +1,73 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"math"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"gopkg.in/validator.v2"
 )
 
 type Receipt struct {
-	Retailer     string `json:"retailer" binding:"required" validate:"regexp=^[\\w\\s\\-&]+$"`
+	Retailer     string `json:"retailer" binding:"required" validate:"validText"`
 	PurchaseDate string `json:"purchaseDate" binding:"required" validate:"validDate"`
 	PurchaseTime string `json:"purchaseTime" binding:"required" validate:"validTime"`
 	Items        []Item `json:"items" binding:"required,dive" validate:"min=1"`
-	Total        string `json:"total" binding:"required" validate:"regexp=^\\d+\\.\\d{2}$"`
+	Total        string `json:"total" binding:"required" validate:"regexp=^\\d+(\\.\\d+)?$"`
+	// Currency is the optional ISO 4217 code of the amounts, like "EUR". The total and the prices have
+	// the decimals of the currency, none for JPY and three for BHD
+	Currency string `json:"currency,omitempty"`
+	// Tax and Tip are the optional tax and tip of the whole receipt
+	Tax string `json:"tax,omitempty" validate:"regexp=^(\\d+(\\.\\d+)?)?$"`
+	Tip string `json:"tip,omitempty" validate:"regexp=^(\\d+(\\.\\d+)?)?$"`
+	// Discounts are the optional discounts of the whole receipt, they are taken off the total
+	Discounts []ReceiptDiscount `json:"discounts,omitempty" binding:"omitempty,dive"`
+	// CustomerID is the optional customer that earns the points for the receipt
+	CustomerID string `json:"customerId,omitempty"`
+	// TimeZone is the optional IANA time zone of the store, like "America/New_York"
+	TimeZone string `json:"timeZone,omitempty"`
+	// PurchasedAt is the purchase date and time as an instant in the store's time zone
+	PurchasedAt time.Time `json:"-"`
+	// Owner is the client that submitted the receipt, it is never read from the request body
+	Owner string `json:"-"`
+	// Tenant is the tenant of the client, it picks the rule overrides the receipt is scored with
+	Tenant string `json:"-"`
+	// RetailerID and RetailerName are the retailer of the registry the printed name was linked to when the
+	// receipt was accepted, both are empty when it matched none
+	RetailerID   string `json:"-"`
+	RetailerName string `json:"-"`
+	// Breakdown is how the receipt was scored when it was accepted
+	Breakdown *PointsBreakdown `json:"-"`
 }
 
 type Item struct {
-	ShortDescription string `json:"shortDescription" binding:"required" validate:"regexp=^[\\w\\s\\-&]+$"`
-	Price            string `json:"price" binding:"required" validate:"regexp=^\\d+\\.\\d{2}$"`
+	ShortDescription string `json:"shortDescription" binding:"required" validate:"validText"`
+	// Price is what was paid for the line before tax, the quantity times the unit price less the discount
+	Price string `json:"price" binding:"required" validate:"regexp=^\\d+(\\.\\d+)?$"`
+	// Quantity is the optional number of units, 1 when it is left out. Weighed items can have a fraction
+	Quantity string `json:"quantity,omitempty" validate:"regexp=^(\\d+(\\.\\d+)?)?$"`
+	// UnitPrice is the optional price of one unit, it can have more decimals than the currency
+	UnitPrice string `json:"unitPrice,omitempty" validate:"regexp=^(\\d+(\\.\\d+)?)?$"`
+	// SKU is the retailer's optional stock keeping unit, UPC the optional GTIN barcode of 8, 12, 13 or 14 digits
+	SKU string `json:"sku,omitempty" validate:"regexp=^[A-Za-z0-9._-]*$"`
+	UPC string `json:"upc,omitempty" validate:"regexp=^(\\d{8}|\\d{12}|\\d{13}|\\d{14})?$"`
+	// Discount and Tax are the optional discount and tax of the line
+	Discount string `json:"discount,omitempty" validate:"regexp=^(\\d+(\\.\\d+)?)?$"`
+	Tax      string `json:"tax,omitempty" validate:"regexp=^(\\d+(\\.\\d+)?)?$"`
 }
 
 type ReceiptCreatedResponse struct {
@@ -31,6 +76,13 @@ type ReceiptCreatedResponse struct {
 
 type PointsGeneratedResponse struct {
 	Points int64 `json:"points"`
+	// RuleVersion is the version of the rule set the points were awarded with
+	RuleVersion string `json:"ruleVersion,omitempty"`
+}
+
+type ReceiptScoreResponse struct {
+	Points    int64            `json:"points"`
+	Breakdown *PointsBreakdown `json:"breakdown"`
 }
 
 // Stores the receipts in memory
@@ -39,42 +91,185 @@ var receiptsMap map[string]Receipt = make(map[string]Receipt)
 // Used to create unique string for receiptId
 var receiptNum int = 0
 
+// Guards receiptsMap and receiptNum
+var receiptsMutex sync.RWMutex
+
 func main() {
+	configPath := flag.String("config", os.Getenv("RECEIPT_PROCESSOR_CONFIG"), "path to the JSON config file")
+	hashKey := flag.String("hash-key", "", "print the hash of an API key for the key file and exit")
+	flag.Parse()
+
+	if *hashKey != "" {
+		fmt.Println(hashAPIKey(*hashKey))
+		return
+	}
+
+	// Load the config file, if there is one
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		slog.Error("unable to load config", slog.String("path", *configPath), slog.Any("error", err))
+		os.Exit(1)
+	}
+
 	// Add validation functions for Time and Date
 	validator.SetValidationFunc("validTime", validTime)
 	validator.SetValidationFunc("validDate", validDate)
+	validator.SetValidationFunc("validText", validText)
+
+	logger := newLogger(cfg.Logging)
+	slog.SetDefault(logger)
+	router, err := setupRouter(cfg, logger)
+	if err != nil {
+		logger.Error("unable to set up router", slog.Any("error", err))
+		os.Exit(1)
+	}
 
-	// Create Gin router
-	router := gin.Default()
+	// Write the expired points to the ledger in the background
+	if cfg.Expiry.Policy != "" {
+		go runExpiryJob(context.Background(), time.Duration(cfg.Expiry.JobIntervalMinutes)*time.Minute, logger)
+	}
 
-	// Define the api paths
-	router.POST("/receipts/process", processReceipt)
-	router.GET("/receipts/:id/points", getPoints)
+	// Reload the rules when the file changes or on SIGHUP
+	if cfg.RulesFile != "" {
+		if cfg.RulesWatchSeconds > 0 {
+			go ruleSets.watch(context.Background(), time.Duration(cfg.RulesWatchSeconds)*time.Second, logger)
+		}
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		go func() {
+			for range hangups {
+				ruleSets.reloadAndLog(logger, "signal")
+			}
+		}()
+	}
 
 	// Start the server
-	router.Run("localhost:8080")
+	if err := router.Run(cfg.Address); err != nil {
+		logger.Error("server stopped", slog.Any("error", err))
+		os.Exit(1)
+	}
 }
 
-// processReceipt validate the JSON body, assigns the receipt a unique id, adds the Receipt to the map, and gives the id to the response
-func processReceipt(c *gin.Context) {
-	var newReceipt Receipt
+// setupRouter creates the Gin router with the middleware and api paths
+func setupRouter(cfg Config, logger *slog.Logger) (*gin.Engine, error) {
+	appConfig = cfg
+	router := gin.New()
+	// Only trust the client IP from the proxies in the config, the IP keys the rate limits of anonymous callers
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
+	router.Use(gin.Recovery())
+	router.Use(requestLogger(logger, cfg.Logging))
+	router.Use(reportRuleVersion)
+
+	// Require an API key or bearer token when either is configured
+	var keys *apiKeyStore
+	var tokens *jwtVerifier
+	var err error
+	if cfg.Auth.APIKeysFile != "" {
+		if keys, err = loadAPIKeys(cfg.Auth.APIKeysFile); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Auth.JWT.JWKS != "" {
+		if tokens, err = newJWTVerifier(cfg.Auth.JWT); err != nil {
+			return nil, err
+		}
+	}
+	if keys != nil || tokens != nil {
+		router.Use(authenticate(keys, tokens))
+	}
 
-	// Check if the requestBody and resulting Receipt is valid, if not it returns 400 BadRequest
-	if err := c.BindJSON(&newReceipt); err != nil {
-		c.String(http.StatusBadRequest, "The receipt is invalid.")
-		return
+	// Load the versions of the scoring rules
+	rules := newRuleSetRegistry()
+	if cfg.RulesFile != "" {
+		if rules, err = loadRuleSets(cfg.RulesFile); err != nil {
+			return nil, err
+		}
 	}
-	// Validate the struct
-	if err := validator.Validate(newReceipt); err != nil {
-		c.String(http.StatusBadRequest, "The receipt is invalid.")
+	ruleSets = rules
+
+	// Find the tenant after the credentials, so a client can not use the data of another tenant
+	router.Use(resolveTenant(cfg.Tenants))
+
+	// Limit the request rate and the number of stored receipts per client
+	router.Use(rateLimit(cfg.RateLimit, newMemoryRateLimiter(time.Now)))
+	quotas := newMemoryQuotaTracker(time.Now)
+
+	// Define the api paths, under the tenant path prefix as well when it is turned on
+	addRoutes(router, logger, cfg, quotas)
+	if cfg.Tenants.PathPrefix {
+		addRoutes(router.Group(tenantPathPrefix), logger, cfg, quotas)
+	}
+
+	return router, nil
+}
+
+// addRoutes registers the api paths on the router or group
+func addRoutes(routes gin.IRoutes, logger *slog.Logger, cfg Config, quotas QuotaTracker) {
+	routes.POST("/receipts/process", requireScope(scopeReceiptsWrite), receiptQuota(cfg.RateLimit, quotas), processReceipt)
+	// Scoring without storing has its own rate limit route and no daily quota
+	routes.POST("/receipts/score", requireScope(scopeReceiptsRead), scoreReceiptOnly)
+	routes.GET("/receipts/:id/points", requireScope(scopeReceiptsRead), getPoints)
+	routes.GET("/receipts/:id/breakdown", requireScope(scopeReceiptsRead), getBreakdown)
+	routes.GET("/receipts/:id/rescore", requireScope(scopeReceiptsRead), rescoreReceiptHandler)
+	routes.PUT("/receipts/:id", requireScope(scopeReceiptsWrite), correctReceipt)
+	routes.DELETE("/receipts/:id", requireScope(scopeReceiptsWrite), deleteReceipt)
+	routes.POST("/customers", requireScope(scopeReceiptsWrite), createCustomer)
+	routes.GET("/customers/:id/balance", requireScope(scopeReceiptsRead), getBalance)
+	routes.GET("/customers/:id/ledger", requireScope(scopeReceiptsRead), getLedger)
+	routes.GET("/customers/:id/expiring", requireScope(scopeReceiptsRead), getExpiringPoints)
+	routes.GET("/customers/:id/tier", requireScope(scopeReceiptsRead), getCustomerTier)
+	routes.GET("/rulesets", requireScope(scopeReceiptsRead), listRuleSets)
+	routes.POST("/rulesets/resolve", requireScope(scopeReceiptsRead), resolveRuleSet)
+	routes.POST("/rulesets/reload", requireScope(scopeAdmin), reloadRuleSets(logger))
+	routes.POST("/rulesets/rollback", requireScope(scopeAdmin), rollbackRuleSets)
+	routes.POST("/simulate", requireScope(scopeReceiptsRead), simulate)
+	routes.GET("/metrics", requireScope(scopeAdmin), getMetrics)
+	routes.POST("/jobs/rescore", requireScope(scopeAdmin), createRescoreJob)
+	routes.GET("/jobs", requireScope(scopeAdmin), listJobs)
+	routes.GET("/jobs/:id", requireScope(scopeAdmin), getJob)
+	routes.POST("/jobs/:id/cancel", requireScope(scopeAdmin), cancelJob)
+	routes.POST("/campaigns", requireScope(scopeAdmin), createCampaign)
+	routes.GET("/campaigns", requireScope(scopeAdmin), listCampaigns)
+	routes.GET("/campaigns/:id", requireScope(scopeAdmin), getCampaign)
+	routes.PUT("/campaigns/:id", requireScope(scopeAdmin), updateCampaign)
+	routes.DELETE("/campaigns/:id", requireScope(scopeAdmin), deleteCampaign)
+	routes.POST("/retailers", requireScope(scopeAdmin), createRetailer)
+	routes.GET("/retailers", requireScope(scopeReceiptsRead), listRetailers)
+	routes.GET("/retailers/summary", requireScope(scopeReceiptsRead), summarizeRetailers)
+	routes.POST("/retailers/match", requireScope(scopeReceiptsRead), matchRetailer)
+	routes.GET("/retailers/:id", requireScope(scopeReceiptsRead), getRetailer)
+	routes.PUT("/retailers/:id", requireScope(scopeAdmin), updateRetailer)
+	routes.DELETE("/retailers/:id", requireScope(scopeAdmin), deleteRetailer)
+	routes.POST("/customers/:id/redemptions", requireScope(scopeReceiptsWrite), createRedemption)
+	routes.GET("/redemptions/:id", requireScope(scopeReceiptsRead), getRedemption)
+	routes.POST("/redemptions/:id/capture", requireScope(scopeReceiptsWrite), captureRedemption)
+	routes.POST("/redemptions/:id/release", requireScope(scopeReceiptsWrite), releaseRedemption)
+}
+
+// processReceipt validate the JSON body, assigns the receipt a unique id, adds the Receipt to the map, and gives the id to the response
+func processReceipt(c *gin.Context) {
+	newReceipt, ok := readReceipt(c)
+	if !ok {
+		metrics.add(metricReceiptsProcessed, 1, tenantLabels(c, "result", "rejected")...)
 		return
 	}
+	newReceipt.Breakdown = scoreReceipt(newReceipt)
 
 	// Generates a unique id and save receipt
 	// This works since the data is not persistant
+	receiptsMutex.Lock()
 	receiptNum += 1
 	var receiptId string = "Receipt" + strconv.Itoa(receiptNum)
 	receiptsMap[receiptId] = newReceipt
+	receiptsMutex.Unlock()
+	c.Set(receiptIDKey, receiptId)
+
+	// Credit the customer with the points for the receipt
+	ledger.creditReceipt(newReceipt, receiptId)
+	metrics.add(metricReceiptsProcessed, 1, tenantLabels(c, "result", "accepted")...)
+	metrics.add(metricPointsAwarded, newReceipt.Breakdown.Total, tenantLabels(c)...)
 
 	// Add id to the Response
 	response := ReceiptCreatedResponse{
@@ -83,50 +278,227 @@ func processReceipt(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// scoreReceiptOnly returns the points the receipt would earn without storing it or crediting the customer
+func scoreReceiptOnly(c *gin.Context) {
+	receipt, ok := readReceipt(c)
+	if !ok {
+		metrics.add(metricReceiptsScored, 1, tenantLabels(c, "result", "rejected")...)
+		return
+	}
+	breakdown := scoreReceipt(receipt)
+	metrics.add(metricReceiptsScored, 1, tenantLabels(c, "result", "accepted")...)
+	metrics.add(metricPointsQuoted, breakdown.Total, tenantLabels(c)...)
+
+	response := ReceiptScoreResponse{
+		Points:    breakdown.Total,
+		Breakdown: breakdown,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// readReceipt binds and validates the JSON body and stamps it with the client, writing the 400 response if it is invalid
+func readReceipt(c *gin.Context) (Receipt, bool) {
+	var newReceipt Receipt
+
+	// Check if the requestBody and resulting Receipt is valid, if not it returns 400 BadRequest
+	if err := bindReceipt(c, requestLimits(c), &newReceipt); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		// Give the reason for the limits and decoding checks
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			c.String(reqErr.Status, "The receipt is invalid: "+reqErr.Reason+".")
+			return newReceipt, false
+		}
+		c.String(http.StatusBadRequest, "The receipt is invalid.")
+		return newReceipt, false
+	}
+	c.Set(receiptLogKey, newReceipt)
+	if err := validateReceipt(c, &newReceipt); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			c.String(reqErr.Status, "The receipt is invalid: "+reqErr.Reason+".")
+			return newReceipt, false
+		}
+		c.String(http.StatusBadRequest, "The receipt is invalid.")
+		return newReceipt, false
+	}
+	return newReceipt, true
+}
+
+// validateReceipt stamps the receipt with the client that submitted it, normalizes it and runs the checks
+// that come after decoding. Errors with a reason for the client are a *requestError
+func validateReceipt(c *gin.Context, receipt *Receipt) error {
+	receipt.Owner = requestOwner(c)
+	receipt.Tenant = requestTenant(c)
+	normalizeText(receipt)
+	linkRetailer(receipt)
+	normalizePurchase(receipt, appConfig.InputFormats, appConfig.TimeZones)
+	// Validate the struct
+	if err := validator.Validate(*receipt); err != nil {
+		return err
+	}
+
+	// The amounts need the decimals of the receipt's currency
+	currency, err := appConfig.Currencies.receiptCurrency(*receipt)
+	if err != nil {
+		return invalidReceipt("%s", err.Error())
+	}
+	receipt.Currency = currency
+	if err := appConfig.Currencies.checkAmounts(*receipt); err != nil {
+		return err
+	}
+	units, _ := appConfig.Currencies.minorUnits(receipt.Currency)
+	if err := checkLineItems(*receipt, units); err != nil {
+		return invalidReceipt("%s", err.Error())
+	}
+
+	// Read the purchase date and time on the store's clock
+	purchasedAt, err := purchaseInstant(*receipt, appConfig.TimeZones)
+	if err != nil {
+		return invalidReceipt("%s", err.Error())
+	}
+	receipt.PurchasedAt = purchasedAt
+	if err := requestPurchaseWindow(c).check(purchasedAt, clock.Now()); err != nil {
+		return invalidReceipt("%s", err.Error())
+	}
+
+	// The customer has to belong to the same client
+	if receipt.CustomerID != "" {
+		if _, ok := findCustomer(receipt.CustomerID, receipt.Owner); !ok {
+			return invalidReceipt("unknown customer %s", receipt.CustomerID)
+		}
+	}
+	return nil
+}
+
+// findReceipt returns the receipt if it exists and belongs to the owner, see ownerKey
+func findReceipt(receiptId string, owner string) (Receipt, bool) {
+	receiptsMutex.RLock()
+	defer receiptsMutex.RUnlock()
+
+	receipt, ok := receiptsMap[receiptId]
+	// Receipts submitted by other clients or tenants are reported as not found
+	if !ok || receipt.Owner != owner {
+		return Receipt{}, false
+	}
+	return receipt, true
+}
+
+// correctReceipt replaces a stored receipt, reversing the points it earned and crediting the corrected points
+func correctReceipt(c *gin.Context) {
+	var receiptId = c.Param("id")
+	c.Set(receiptIDKey, receiptId)
+	oldReceipt, ok := findReceipt(receiptId, requestOwner(c))
+	if !ok {
+		c.String(http.StatusNotFound, "No receipt found for that ID.")
+		return
+	}
+	newReceipt, ok := readReceipt(c)
+	if !ok {
+		return
+	}
+	newReceipt.Breakdown = scoreReceipt(newReceipt)
+
+	receiptsMutex.Lock()
+	receiptsMap[receiptId] = newReceipt
+	receiptsMutex.Unlock()
+
+	ledger.reverseReceipt(oldReceipt.CustomerID, receiptId)
+	ledger.creditReceipt(newReceipt, receiptId)
+	c.JSON(http.StatusOK, ReceiptCreatedResponse{ID: receiptId})
+}
+
+// deleteReceipt removes a stored receipt and reverses the points it earned
+func deleteReceipt(c *gin.Context) {
+	var receiptId = c.Param("id")
+	c.Set(receiptIDKey, receiptId)
+	receipt, ok := findReceipt(receiptId, requestOwner(c))
+	if !ok {
+		c.String(http.StatusNotFound, "No receipt found for that ID.")
+		return
+	}
+
+	receiptsMutex.Lock()
+	delete(receiptsMap, receiptId)
+	receiptsMutex.Unlock()
+
+	ledger.reverseReceipt(receipt.CustomerID, receiptId)
+	c.Status(http.StatusNoContent)
+}
+
 // getPoints calculates and returns the amount of points awarded for a receipt given the receiptId
 func getPoints(c *gin.Context) {
 	// Check if the receiptId is valid, if not return a 404 NotFound
 	var receiptId = c.Param("id")
-	receipt, ok := receiptsMap[receiptId]
+	c.Set(receiptIDKey, receiptId)
+	receipt, ok := findReceipt(receiptId, requestOwner(c))
 	if !ok {
 		c.String(http.StatusNotFound, "No receipt found for that ID.")
 		return
 	}
 
-	// Calcuate and add points to context response
-	var points int64 = calcuatePoints(receipt)
+	// Return the points recorded when the receipt was accepted, so changing the rules does not change them
+	var points int64 = receiptPoints(receipt)
 	response := PointsGeneratedResponse{
 		Points: points,
 	}
+	if receipt.Breakdown != nil {
+		response.RuleVersion = receipt.Breakdown.RuleVersion
+	}
 	c.JSON(http.StatusOK, response)
 }
 
-// CalcualtePoints gets and adds up all the points for the receipt
+// CalcualtePoints gets and adds up all the points for the receipt with the rule set that applies to it
 func calcuatePoints(receipt Receipt) int64 {
 	var points int64 = 0
-	points += getCountAlphanumericPoints(receipt.Retailer)
-
-	points += getRoundDollarPoints(receipt.Total)
-
-	points += getMultipleOfQuarterPoints(receipt.Total)
-
-	points += getPairsPoints(receipt.Items)
-
-	points += getItemTrimmedLengthPoints(receipt.Items)
-
-	points += getPurchaseDatePoints(receipt.PurchaseDate)
-
-	points += getPurchaseTimePoints(receipt.PurchaseTime)
+	rules, _ := ruleSets.resolve(receipt.Tenant, receipt.canonicalRetailer())
+	for _, rule := range rules.rulePoints(receipt) {
+		points += rule.Points
+	}
+	return points
+}
 
+// rulePoints gets the points for each rule separately, the custom rules come after the built-in ones
+func (rules RuleSet) rulePoints(receipt Receipt) []RulePoints {
+	// The date and time rules use the store's clock
+	purchaseDate, purchaseTime := receipt.localPurchase()
+	// The amount rules use the receipt's currency, or the base currency when there is one
+	total := appConfig.Currencies.ruleTotal(receipt)
+	points := []RulePoints{
+		{Rule: "retailerName", Points: rules.countAlphanumericPoints(receipt.Retailer)},
+		{Rule: "roundDollar", Points: rules.roundDollarPoints(total)},
+		{Rule: "multipleOfQuarter", Points: rules.multipleOfQuarterPoints(total)},
+		{Rule: "itemPairs", Points: rules.pairsPoints(receipt.Items)},
+		{Rule: "itemDescriptionLength", Points: rules.itemTrimmedLengthPoints(appConfig.Currencies.ruleItems(receipt))},
+		{Rule: "oddPurchaseDay", Points: rules.purchaseDatePoints(purchaseDate)},
+		{Rule: "afternoonPurchase", Points: rules.purchaseTimePoints(purchaseTime)},
+	}
+	if len(rules.programs) == 0 {
+		return points
+	}
+	data := newExprReceipt(receipt)
+	for _, program := range rules.programs {
+		customPoints, err := program.run(data, expressionTimeout)
+		if err != nil {
+			slog.Warn("custom rule failed", slog.String("rule", program.name), slog.String("ruleVersion", rules.Version), slog.Any("error", err))
+		}
+		points = append(points, RulePoints{Rule: program.name, Points: customPoints})
+	}
 	return points
 }
 
 // One point for every alphanumeric character in the retailer name
 func getCountAlphanumericPoints(retailer string) int64 {
+	return defaultRuleSet().countAlphanumericPoints(retailer)
+}
+
+// RetailerCharacterPoints for every alphanumeric character in the retailer name
+func (rules RuleSet) countAlphanumericPoints(retailer string) int64 {
 	var points int64 = 0
 	for _, r := range retailer {
 		if unicode.IsLetter(r) || unicode.IsNumber(r) {
-			points += 1
+			points += rules.RetailerCharacterPoints
 		}
 	}
 	return points
@@ -134,9 +506,14 @@ func getCountAlphanumericPoints(retailer string) int64 {
 
 // 50 points if the total is a round dollar amount with no cents
 func getRoundDollarPoints(total string) int64 {
-	result := strings.SplitAfter(total, ".")
-	if result[1] == "00" {
-		return 50
+	return defaultRuleSet().roundDollarPoints(total)
+}
+
+// RoundDollarPoints if the total is a round amount with no minor units, any number of decimals is read
+func (rules RuleSet) roundDollarPoints(total string) int64 {
+	_, fraction, _ := strings.Cut(total, ".")
+	if strings.Trim(fraction, "0") == "" {
+		return rules.RoundDollarPoints
 	} else {
 		return 0
 	}
@@ -144,39 +521,57 @@ func getRoundDollarPoints(total string) int64 {
 
 // 25 points if the total is a multiple of 0.25
 func getMultipleOfQuarterPoints(total string) int64 {
-	result := strings.SplitAfter(total, ".")
-	centAmount, _ := strconv.Atoi(result[1])
-	// If cents is 00, 25, 50, 75 then add the points
-	if centAmount%25 == 0 {
-		return 25
-	} else {
+	return defaultRuleSet().multipleOfQuarterPoints(total)
+}
+
+// QuarterMultiplePoints if the total is a multiple of 0.25
+func (rules RuleSet) multipleOfQuarterPoints(total string) int64 {
+	_, fraction, _ := strings.Cut(total, ".")
+	// If the fraction is .00, .25, .50 or .75, with any number of trailing zeros, then add the points
+	switch strings.TrimRight(fraction, "0") {
+	case "", "25", "5", "75":
+		return rules.QuarterMultiplePoints
+	default:
 		return 0
 	}
 }
 
 // 5 points for every two items on the receipt
 func getPairsPoints(items []Item) int64 {
-	numItems := len(items)
+	return defaultRuleSet().pairsPoints(items)
+}
+
+// PairPoints for every two items on the receipt, counted as lines or units as the rule set says
+func (rules RuleSet) pairsPoints(items []Item) int64 {
+	numItems := int64(len(items))
+	if rules.PairCounting == pairCountingUnits {
+		numItems = countUnits(items)
+	}
 	numPairs := numItems / 2
-	return int64(numPairs * 5)
+	return int64(numPairs) * rules.PairPoints
 }
 
 // If the trimmed length of the item description is a multiple of 3, multiply the price by 0.2 and round up to the nearest integer. The result is the number of points earned
 func getItemTrimmedLengthPoints(items []Item) int64 {
+	return defaultRuleSet().itemTrimmedLengthPoints(items)
+}
+
+// If the trimmed length of the item description is a multiple of DescriptionLengthMultiple, multiply the price by DescriptionPriceMultiplier and round up to the nearest integer
+func (rules RuleSet) itemTrimmedLengthPoints(items []Item) int64 {
 	var points int64 = 0
 	// for each item
 	for _, item := range items {
 		// use strings.TrimSpace to remove the leading and trailing whitespace
 		trimmedItemDescription := strings.TrimSpace(item.ShortDescription)
-		// Get lgenth
-		trimmedLength := len(trimmedItemDescription)
-		// If trimmed length is a multiple of 3
-		if trimmedLength%3 == 0 {
-			// multiple the price by .2
+		// Get lgenth in characters, so non-ASCII descriptions are not counted in bytes
+		trimmedLength := utf8.RuneCountInString(trimmedItemDescription)
+		// If trimmed length is a multiple of the configured length
+		if trimmedLength%rules.DescriptionLengthMultiple == 0 {
+			// multiple the price by the configured multiplier
 			val, _ := strconv.ParseFloat(item.Price, 64)
 			// round up to nearest integer
 			// add this number to points
-			points += int64(math.Ceil(val * .2))
+			points += int64(math.Ceil(val * rules.DescriptionPriceMultiplier))
 		}
 	}
 	return points
@@ -184,11 +579,16 @@ func getItemTrimmedLengthPoints(items []Item) int64 {
 
 // 6 points if the day in the purchase date is odd
 func getPurchaseDatePoints(purchaseDate string) int64 {
+	return defaultRuleSet().purchaseDatePoints(purchaseDate)
+}
+
+// OddDayPoints if the day in the purchase date is odd
+func (rules RuleSet) purchaseDatePoints(purchaseDate string) int64 {
 	format := "2006-01-02"
 	// Already checked for valid date with validator
 	date, _ := time.Parse(format, purchaseDate)
 	if date.Day()%2 == 1 {
-		return 6
+		return rules.OddDayPoints
 	} else {
 		return 0
 	}
@@ -196,22 +596,27 @@ func getPurchaseDatePoints(purchaseDate string) int64 {
 
 // 10 points if the time of purchase is after 2:00pm and before 4:00pm
 func getPurchaseTimePoints(purchaseTime string) int64 {
+	return defaultRuleSet().purchaseTimePoints(purchaseTime)
+}
+
+// AfternoonPoints if the time of purchase is between AfternoonStart and AfternoonEnd
+func (rules RuleSet) purchaseTimePoints(purchaseTime string) int64 {
 	format := "15:04"
 	// Already checked for valid time with validator
 	pTime, _ := time.Parse(format, purchaseTime)
-	twoPm, _ := time.Parse(format, "14:00")
-	fourPm, _ := time.Parse(format, "16:00")
-	if isBetweenTimeRange(pTime, twoPm, fourPm) {
-		return 10
+	start, _ := time.Parse(format, rules.AfternoonStart)
+	end, _ := time.Parse(format, rules.AfternoonEnd)
+	if isBetweenTimeRange(pTime, start, end) {
+		return rules.AfternoonPoints
 	} else {
 		return 0
 	}
 }
 
-// Check to see if given time is between 2pm and 4pm, inclusive of 2pm and 4pm
+// Check to see if given time is between the first and second time, inclusive of both
 func isBetweenTimeRange(pTime time.Time, firstTime time.Time, secondTime time.Time) bool {
 	if (pTime.After(firstTime) && pTime.Before(secondTime)) || (pTime.Equal(firstTime) || (pTime.Equal(secondTime))) {
 		return true
 	}
 	return false
-}
\ No newline at end of file
+}
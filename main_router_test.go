@@ -19,12 +19,55 @@ import (
 func setup() {
 	validator.SetValidationFunc("validTime", validTime)
 	validator.SetValidationFunc("validDate", validDate)
+	validator.SetValidationFunc("validText", validText)
 }
 
 func teardown() {
 	for k := range receiptsMap {
 		delete(receiptsMap, k)
 	}
+	receiptNum = 0
+	appConfig = defaultConfig()
+	for k := range customersMap {
+		delete(customersMap, k)
+	}
+	customerNum = 0
+	ledger = newPointsLedger()
+	tiers = newTierTracker()
+	campaigns = newCampaignStore()
+	retailers = newRetailerStore()
+	ruleSets = newRuleSetRegistry()
+	jobs = newJobStore()
+	metrics = newMetricsRegistry()
+	clock = systemClock{}
+}
+
+// newTestRouter creates the router for the config with the logs written to a buffer
+func newTestRouter(t *testing.T, cfg Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	router, err := setupRouter(cfg, newTestLogger(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+// serveJSON sends the request with body encoded as JSON, body can be nil
+func serveJSON(router *gin.Engine, method string, path string, body any, headers ...string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		jsonbytes, _ := json.Marshal(body)
+		reader = bytes.NewBuffer(jsonbytes)
+	}
+	req, _ := http.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
 }
 
 // Receipts
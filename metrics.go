@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Names of the counters
+const (
+	// metricReceiptsProcessed counts POST /receipts/process requests by result, the receipts are stored
+	metricReceiptsProcessed = "receipts_processed_total"
+	// metricReceiptsScored counts POST /receipts/score requests by result, the receipts are not stored
+	metricReceiptsScored = "receipts_scored_total"
+	// metricPointsAwarded is the sum of the points of the stored receipts
+	metricPointsAwarded = "points_awarded_total"
+	// metricPointsQuoted is the sum of the points returned for receipts that were only scored
+	metricPointsQuoted = "points_quoted_total"
+)
+
+// metricsRegistry keeps counters in memory and writes them in the Prometheus text format
+type metricsRegistry struct {
+	mutex    sync.Mutex
+	counters map[string]int64
+}
+
+// newMetricsRegistry creates a registry with no counters
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{counters: make(map[string]int64)}
+}
+
+// Stores the counters in memory
+var metrics *metricsRegistry = newMetricsRegistry()
+
+// add adds value to the counter with the name and the label name and value pairs
+func (m *metricsRegistry) add(name string, value int64, labels ...string) {
+	series := name
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+		}
+		series += "{" + strings.Join(pairs, ",") + "}"
+	}
+	m.mutex.Lock()
+	m.counters[series] += value
+	m.mutex.Unlock()
+}
+
+// get returns the value of the counter series, like `receipts_scored_total{result="accepted"}`
+func (m *metricsRegistry) get(series string) int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.counters[series]
+}
+
+// text returns the counters in the Prometheus text format ordered by name, only the series that contain
+// filter when it is not empty
+func (m *metricsRegistry) text(filter string) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	series := make([]string, 0, len(m.counters))
+	for name := range m.counters {
+		if strings.Contains(name, filter) {
+			series = append(series, name)
+		}
+	}
+	sort.Strings(series)
+	var builder strings.Builder
+	for _, name := range series {
+		fmt.Fprintf(&builder, "%s %d\n", name, m.counters[name])
+	}
+	return builder.String()
+}
+
+// getMetrics returns the counters, only the ones labelled with the tenant of the request when there is one
+func getMetrics(c *gin.Context) {
+	filter := ""
+	if tenant := requestTenant(c); tenant != "" {
+		filter = fmt.Sprintf("tenant=%q", tenant)
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(metrics.text(filter)))
+}
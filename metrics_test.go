@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMetricsText
+func TestMetricsText(t *testing.T) {
+	m := newMetricsRegistry()
+	m.add(metricReceiptsScored, 1, "result", "accepted")
+	m.add(metricReceiptsScored, 1, "result", "accepted")
+	m.add(metricPointsQuoted, 28)
+	assert.Equal(t, int64(2), m.get(`receipts_scored_total{result="accepted"}`))
+	assert.Equal(t, "points_quoted_total 28\nreceipts_scored_total{result=\"accepted\"} 2\n", m.text(""))
+}
+
+// TestReceiptMetricsAreSeparate
+func TestReceiptMetricsAreSeparate(t *testing.T) {
+	setup()
+	router := newTestRouter(t, defaultConfig())
+
+	serveJSON(router, "POST", "/receipts/score", validReceipt1)
+	serveJSON(router, "POST", "/receipts/score", receiptInvalidTotal)
+	serveJSON(router, "POST", "/receipts/process", validReceipt2)
+
+	assert.Equal(t, int64(1), metrics.get(`receipts_scored_total{result="accepted"}`))
+	assert.Equal(t, int64(1), metrics.get(`receipts_scored_total{result="rejected"}`))
+	assert.Equal(t, int64(28), metrics.get(metricPointsQuoted))
+	assert.Equal(t, int64(1), metrics.get(`receipts_processed_total{result="accepted"}`))
+	assert.Equal(t, int64(0), metrics.get(`receipts_processed_total{result="rejected"}`))
+	assert.Equal(t, int64(109), metrics.get(metricPointsAwarded))
+
+	w := serveJSON(router, "GET", "/metrics", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "receipts_scored_total{result=\"rejected\"} 1\n")
+
+	teardown()
+}
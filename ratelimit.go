@@ -0,0 +1,252 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Buckets that have not been used for this long are dropped from memory
+const bucketIdleTimeout = 10 * time.Minute
+
+// RateLimit is a token bucket refilled at RequestsPerSecond that holds at most Burst requests
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	Burst             int     `json:"burst"`
+}
+
+// RateDecision is the outcome of taking a token from a bucket
+type RateDecision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next token is available when the request is not allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// QuotaDecision is the outcome of using one unit of a daily quota
+type QuotaDecision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the quota starts over at midnight UTC
+	Reset time.Duration
+}
+
+// RateLimiter takes tokens from the bucket for key, implementations must be safe for concurrent use
+type RateLimiter interface {
+	Take(key string, limit RateLimit) RateDecision
+}
+
+// QuotaTracker counts daily usage for key, implementations must be safe for concurrent use
+type QuotaTracker interface {
+	// Use counts one unit against the quota if there is any left
+	Use(key string, quota int) QuotaDecision
+	// Refund gives back a unit that was used for a request that failed
+	Refund(key string)
+}
+
+// tokenBucket is the state of one client's bucket for one route
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// memoryRateLimiter keeps the token buckets in memory
+type memoryRateLimiter struct {
+	mutex     sync.Mutex
+	now       func() time.Time
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newMemoryRateLimiter creates an empty in-memory rate limiter
+func newMemoryRateLimiter(now func() time.Time) *memoryRateLimiter {
+	return &memoryRateLimiter{now: now, buckets: make(map[string]*tokenBucket), lastSweep: now()}
+}
+
+// Take refills the bucket for the time since it was last used and takes one token from it
+func (l *memoryRateLimiter) Take(key string, limit RateLimit) RateDecision {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	burst := float64(limit.Burst)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, lastSeen: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*limit.RequestsPerSecond)
+	bucket.lastSeen = now
+
+	decision := RateDecision{Limit: limit.Burst}
+	if bucket.tokens >= 1 {
+		bucket.tokens -= 1
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = secondsToDuration((1 - bucket.tokens) / limit.RequestsPerSecond)
+	}
+	decision.Remaining = int(bucket.tokens)
+	decision.Reset = secondsToDuration((burst - bucket.tokens) / limit.RequestsPerSecond)
+	return decision
+}
+
+// sweep drops the buckets that have been idle long enough to be full again
+func (l *memoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < bucketIdleTimeout {
+		return
+	}
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > bucketIdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// memoryQuotaTracker keeps the daily usage in memory, usage starts over at midnight UTC
+type memoryQuotaTracker struct {
+	mutex sync.Mutex
+	now   func() time.Time
+	day   string
+	used  map[string]int
+}
+
+// newMemoryQuotaTracker creates an empty in-memory quota tracker
+func newMemoryQuotaTracker(now func() time.Time) *memoryQuotaTracker {
+	return &memoryQuotaTracker{now: now, used: make(map[string]int)}
+}
+
+// Use counts one unit for key if it has not used the whole quota today
+func (q *memoryQuotaTracker) Use(key string, quota int) QuotaDecision {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := q.now().UTC()
+	q.startDay(now)
+	decision := QuotaDecision{Limit: quota, Reset: untilMidnight(now)}
+	if q.used[key] < quota {
+		q.used[key] += 1
+		decision.Allowed = true
+	}
+	decision.Remaining = quota - q.used[key]
+	return decision
+}
+
+// Refund gives back one unit for key
+func (q *memoryQuotaTracker) Refund(key string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.startDay(q.now().UTC())
+	if q.used[key] > 0 {
+		q.used[key] -= 1
+	}
+}
+
+// startDay clears the usage when the day has changed
+func (q *memoryQuotaTracker) startDay(now time.Time) {
+	day := now.Format("2006-01-02")
+	if day != q.day {
+		q.day = day
+		q.used = make(map[string]int)
+	}
+}
+
+// untilMidnight returns the time left until the next midnight UTC
+func untilMidnight(now time.Time) time.Duration {
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return midnight.Sub(now)
+}
+
+// secondsToDuration converts fractional seconds into a duration
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// headerSeconds rounds a duration up to whole seconds for the response headers
+func headerSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimitKey identifies the caller by its client id, or by its IP address when it is not authenticated,
+// within its tenant
+func rateLimitKey(c *gin.Context) string {
+	key := "ip:" + c.ClientIP()
+	if clientID := requestClient(c); clientID != "" {
+		key = "client:" + clientID
+	}
+	if tenant := requestTenant(c); tenant != "" {
+		key = "tenant:" + tenant + " " + key
+	}
+	return key
+}
+
+// clientTier returns the rate limit tier for the caller
+func clientTier(cfg RateLimitConfig, c *gin.Context) RateTier {
+	tierName := cfg.DefaultTier
+	if name, ok := cfg.Clients[requestClient(c)]; ok {
+		tierName = name
+	}
+	return cfg.Tiers[tierName]
+}
+
+// rateLimit rejects requests with 429 once the caller's bucket for the route is empty
+func rateLimit(cfg RateLimitConfig, limiter RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tier := clientTier(requestRateLimit(cfg, c), c)
+		route := c.Request.Method + " " + routePath(c)
+		limit, ok := tier.Routes[route]
+		if !ok {
+			limit, ok = tier.Routes["*"]
+		}
+		if !ok || limit.RequestsPerSecond <= 0 {
+			c.Next()
+			return
+		}
+
+		decision := limiter.Take(rateLimitKey(c)+" "+route, limit)
+		c.Header("RateLimit-Limit", strconv.Itoa(decision.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		c.Header("RateLimit-Reset", headerSeconds(decision.Reset))
+		if !decision.Allowed {
+			c.Header("Retry-After", headerSeconds(decision.RetryAfter))
+			c.String(http.StatusTooManyRequests, "Too many requests.")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// receiptQuota limits how many receipts the caller can store each day, receipts that are rejected are not counted
+func receiptQuota(cfg RateLimitConfig, quotas QuotaTracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		quota := clientTier(requestRateLimit(cfg, c), c).DailyReceiptQuota
+		if quota <= 0 {
+			c.Next()
+			return
+		}
+
+		key := rateLimitKey(c)
+		decision := quotas.Use(key, quota)
+		if !decision.Allowed {
+			c.Header("Retry-After", headerSeconds(decision.Reset))
+			c.String(http.StatusTooManyRequests, "Daily receipt quota exceeded.")
+			c.Abort()
+			return
+		}
+		c.Next()
+		if c.Writer.Status() != http.StatusOK {
+			quotas.Refund(key)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a clock the tests move forward by hand
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+// TestTokenBucketRefills
+func TestTokenBucketRefills(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := newMemoryRateLimiter(clock.Now)
+	limit := RateLimit{RequestsPerSecond: 1, Burst: 2}
+
+	assert.True(t, limiter.Take("a", limit).Allowed)
+	decision := limiter.Take("a", limit)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 0, decision.Remaining)
+
+	decision = limiter.Take("a", limit)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, time.Second, decision.RetryAfter)
+
+	// Other keys have their own bucket
+	assert.True(t, limiter.Take("b", limit).Allowed)
+
+	clock.now = clock.now.Add(time.Second)
+	assert.True(t, limiter.Take("a", limit).Allowed)
+	assert.False(t, limiter.Take("a", limit).Allowed)
+}
+
+// TestQuotaTrackerStartsOverAtMidnight
+func TestQuotaTrackerStartsOverAtMidnight(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)}
+	quotas := newMemoryQuotaTracker(clock.Now)
+
+	assert.True(t, quotas.Use("a", 2).Allowed)
+	assert.True(t, quotas.Use("a", 2).Allowed)
+	decision := quotas.Use("a", 2)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, time.Hour, decision.Reset)
+
+	quotas.Refund("a")
+	assert.True(t, quotas.Use("a", 2).Allowed)
+
+	clock.now = clock.now.Add(time.Hour)
+	assert.True(t, quotas.Use("a", 2).Allowed)
+}
+
+// newRateLimitedRouter creates a router with a burst of 2 receipts and a daily quota of 3 for everyone
+func newRateLimitedRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	cfg := defaultConfig()
+	cfg.RateLimit = RateLimitConfig{
+		DefaultTier: "free",
+		Tiers: map[string]RateTier{
+			"free": {
+				Routes: map[string]RateLimit{
+					"POST /receipts/process": {RequestsPerSecond: 0.001, Burst: 2},
+				},
+				DailyReceiptQuota: 3,
+			},
+		},
+	}
+	var buf bytes.Buffer
+	router, err := setupRouter(cfg, newTestLogger(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+// postReceipt sends the receipt to the router from the given address
+func postReceipt(router *gin.Engine, receipt Receipt, remoteAddr string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	jsonbytes, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(jsonbytes))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = remoteAddr
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestRateLimitReturns429
+func TestRateLimitReturns429(t *testing.T) {
+	setup()
+	router := newRateLimitedRouter(t)
+
+	w := postReceipt(router, validReceipt1, "10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+
+	postReceipt(router, validReceipt1, "10.0.0.1:1234")
+	w = postReceipt(router, validReceipt1, "10.0.0.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	// Another client is not affected and other routes are not limited
+	w = postReceipt(router, validReceipt1, "10.0.0.2:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/receipts/Receipt1/points", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+
+	teardown()
+}
+
+// TestRateLimitIgnoresForwardedFor
+func TestRateLimitIgnoresForwardedFor(t *testing.T) {
+	setup()
+	router := newRateLimitedRouter(t)
+
+	// A client can not get a new bucket by sending another X-Forwarded-For with each request
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		jsonbytes, _ := json.Marshal(validReceipt1)
+		req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(jsonbytes))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i))
+		req.RemoteAddr = "10.0.0.1:1234"
+		router.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+
+	teardown()
+}
+
+// TestTrustedProxies
+func TestTrustedProxies(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.TrustedProxies = []string{"10.0.0.0/8"}
+	var buf bytes.Buffer
+	router, err := setupRouter(cfg, newTestLogger(&buf))
+	assert.NoError(t, err)
+	router.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/ip", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	req.RemoteAddr = "10.0.0.1:1234"
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "203.0.113.7", w.Body.String())
+
+	cfg.TrustedProxies = []string{"not an address"}
+	_, err = setupRouter(cfg, newTestLogger(&buf))
+	assert.Error(t, err)
+}
+
+// TestReceiptQuotaSkipsRejectedReceipts
+func TestReceiptQuotaSkipsRejectedReceipts(t *testing.T) {
+	setup()
+	gin.SetMode(gin.TestMode)
+	cfg := defaultConfig()
+	cfg.RateLimit = RateLimitConfig{
+		DefaultTier: "free",
+		Tiers:       map[string]RateTier{"free": {DailyReceiptQuota: 2}},
+	}
+	var buf bytes.Buffer
+	router, _ := setupRouter(cfg, newTestLogger(&buf))
+
+	assert.Equal(t, http.StatusBadRequest, postReceipt(router, receiptInvalidTotal, "10.0.1.1:1").Code)
+	assert.Equal(t, http.StatusOK, postReceipt(router, validReceipt1, "10.0.1.1:1").Code)
+	assert.Equal(t, http.StatusOK, postReceipt(router, validReceipt2, "10.0.1.1:1").Code)
+	w := postReceipt(router, validReceipt3, "10.0.1.1:1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "Daily receipt quota exceeded.", w.Body.String())
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Len(t, receiptsMap, 2)
+
+	teardown()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Statuses of a redemption
+const (
+	redemptionHeld     = "held"
+	redemptionCaptured = "captured"
+	redemptionReleased = "released"
+)
+
+var (
+	errInsufficientPoints = errors.New("insufficient points")
+	errRedemptionNotFound = errors.New("redemption not found")
+	errRedemptionClosed   = errors.New("redemption is already closed")
+)
+
+// Redemption is a hold on a customer's points that is later captured or released
+type Redemption struct {
+	ID          string    `json:"id"`
+	CustomerID  string    `json:"customerId"`
+	Points      int64     `json:"points"`
+	Description string    `json:"description,omitempty"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// Owner is the client that created the redemption
+	Owner string `json:"-"`
+}
+
+type CreateRedemptionRequest struct {
+	Points      int64  `json:"points" binding:"required,gt=0"`
+	Description string `json:"description" binding:"max=256"`
+}
+
+// hold reserves points from the customer's balance, failing if the balance is too low
+func (l *pointsLedger) hold(customerID string, points int64, description string, owner string) (Redemption, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.balanceLocked(customerID) < points {
+		return Redemption{}, errInsufficientPoints
+	}
+	redemption := &Redemption{
+		ID:          "Hold" + strconv.Itoa(len(l.holds)+1),
+		CustomerID:  customerID,
+		Points:      points,
+		Description: description,
+		Status:      redemptionHeld,
+		CreatedAt:   l.now().UTC(),
+		Owner:       owner,
+	}
+	l.holds[redemption.ID] = redemption
+	l.appendLocked(LedgerEntry{CustomerID: customerID, Type: entryHold, Points: -points, HoldID: redemption.ID})
+	return *redemption, nil
+}
+
+// redemption returns the redemption if it belongs to the client
+func (l *pointsLedger) redemption(holdID string, owner string) (Redemption, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	redemption, ok := l.holds[holdID]
+	if !ok || redemption.Owner != owner {
+		return Redemption{}, errRedemptionNotFound
+	}
+	return *redemption, nil
+}
+
+// heldPoints adds up the customer's redemptions that are still on hold
+func (l *pointsLedger) heldPoints(customerID string) int64 {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	var held int64 = 0
+	for _, redemption := range l.holds {
+		if redemption.CustomerID == customerID && redemption.Status == redemptionHeld {
+			held += redemption.Points
+		}
+	}
+	return held
+}
+
+// capture marks the held points as spent, capturing a captured redemption again does nothing
+func (l *pointsLedger) capture(holdID string, owner string) (Redemption, error) {
+	return l.closeHold(holdID, owner, redemptionCaptured)
+}
+
+// release gives the held points back, releasing a released redemption again does nothing
+func (l *pointsLedger) release(holdID string, owner string) (Redemption, error) {
+	return l.closeHold(holdID, owner, redemptionReleased)
+}
+
+// closeHold moves a held redemption to status and records it in the ledger
+func (l *pointsLedger) closeHold(holdID string, owner string, status string) (Redemption, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	redemption, ok := l.holds[holdID]
+	if !ok || redemption.Owner != owner {
+		return Redemption{}, errRedemptionNotFound
+	}
+	if redemption.Status == status {
+		return *redemption, nil
+	}
+	if redemption.Status != redemptionHeld {
+		return *redemption, errRedemptionClosed
+	}
+
+	redemption.Status = status
+	entry := LedgerEntry{CustomerID: redemption.CustomerID, HoldID: redemption.ID}
+	if status == redemptionCaptured {
+		entry.Type = entryCapture
+	} else {
+		entry.Type = entryRelease
+		entry.Points = redemption.Points
+	}
+	l.appendLocked(entry)
+	return *redemption, nil
+}
+
+// createRedemption places a hold on the customer's points
+func createRedemption(c *gin.Context) {
+	customer, ok := findCustomer(c.Param("id"), requestOwner(c))
+	if !ok {
+		c.String(http.StatusNotFound, "No customer found for that ID.")
+		return
+	}
+	var request CreateRedemptionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusBadRequest, "The redemption is invalid.")
+		return
+	}
+
+	redemption, err := ledger.hold(customer.ID, request.Points, request.Description, requestOwner(c))
+	if err != nil {
+		c.String(http.StatusConflict, "Insufficient points.")
+		return
+	}
+	c.JSON(http.StatusOK, redemption)
+}
+
+// getRedemption returns the redemption and its status
+func getRedemption(c *gin.Context) {
+	redemption, err := ledger.redemption(c.Param("id"), requestOwner(c))
+	if err != nil {
+		c.String(http.StatusNotFound, "No redemption found for that ID.")
+		return
+	}
+	c.JSON(http.StatusOK, redemption)
+}
+
+// captureRedemption spends the held points
+func captureRedemption(c *gin.Context) {
+	respondRedemption(c, ledger.capture)
+}
+
+// releaseRedemption gives the held points back to the customer
+func releaseRedemption(c *gin.Context) {
+	respondRedemption(c, ledger.release)
+}
+
+// respondRedemption runs the capture or release and writes the result
+func respondRedemption(c *gin.Context, closeHold func(holdID string, owner string) (Redemption, error)) {
+	redemption, err := closeHold(c.Param("id"), requestOwner(c))
+	switch {
+	case errors.Is(err, errRedemptionNotFound):
+		c.String(http.StatusNotFound, "No redemption found for that ID.")
+	case errors.Is(err, errRedemptionClosed):
+		c.String(http.StatusConflict, "The redemption is already "+redemption.Status+".")
+	default:
+		c.JSON(http.StatusOK, redemption)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// newCustomerWithPoints creates Customer1 and earns it 137 points with two receipts
+func newCustomerWithPoints(t *testing.T) *gin.Engine {
+	router := newTestRouter(t, defaultConfig())
+	serveJSON(router, "POST", "/customers", CreateCustomerRequest{Name: "Ada"})
+	receipt1 := validReceipt1
+	receipt1.CustomerID = "Customer1"
+	receipt2 := validReceipt2
+	receipt2.CustomerID = "Customer1"
+	serveJSON(router, "POST", "/receipts/process", receipt1)
+	serveJSON(router, "POST", "/receipts/process", receipt2)
+	return router
+}
+
+// decodeRedemption reads the redemption from the response
+func decodeRedemption(body []byte) Redemption {
+	var redemption Redemption
+	json.Unmarshal(body, &redemption)
+	return redemption
+}
+
+// TestRedemptionHoldAndCapture
+func TestRedemptionHoldAndCapture(t *testing.T) {
+	setup()
+	router := newCustomerWithPoints(t)
+
+	w := serveJSON(router, "POST", "/customers/Customer1/redemptions", CreateRedemptionRequest{Points: 100})
+	assert.Equal(t, http.StatusOK, w.Code)
+	redemption := decodeRedemption(w.Body.Bytes())
+	assert.Equal(t, "Hold1", redemption.ID)
+	assert.Equal(t, redemptionHeld, redemption.Status)
+
+	// The held points can not be spent twice
+	assert.Equal(t, int64(37), ledger.balance("Customer1"))
+	assert.Equal(t, int64(100), ledger.heldPoints("Customer1"))
+	w = serveJSON(router, "POST", "/customers/Customer1/redemptions", CreateRedemptionRequest{Points: 38})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "Insufficient points.", w.Body.String())
+
+	// Capturing twice only records one capture
+	for i := 0; i < 2; i++ {
+		w = serveJSON(router, "POST", "/redemptions/Hold1/capture", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, redemptionCaptured, decodeRedemption(w.Body.Bytes()).Status)
+	}
+	entries := ledger.entriesFor("Customer1")
+	assert.Len(t, entries, 4)
+	assert.Equal(t, entryHold, entries[2].Type)
+	assert.Equal(t, int64(-100), entries[2].Points)
+	assert.Equal(t, entryCapture, entries[3].Type)
+	assert.Equal(t, "Hold1", entries[3].HoldID)
+	assert.Equal(t, int64(37), ledger.balance("Customer1"))
+	assert.Equal(t, int64(0), ledger.heldPoints("Customer1"))
+
+	// A captured redemption can not be released
+	w = serveJSON(router, "POST", "/redemptions/Hold1/release", nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "The redemption is already captured.", w.Body.String())
+
+	teardown()
+}
+
+// TestRedemptionRelease
+func TestRedemptionRelease(t *testing.T) {
+	setup()
+	router := newCustomerWithPoints(t)
+
+	serveJSON(router, "POST", "/customers/Customer1/redemptions", CreateRedemptionRequest{Points: 137})
+	assert.Equal(t, int64(0), ledger.balance("Customer1"))
+
+	w := serveJSON(router, "POST", "/redemptions/Hold1/release", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, redemptionReleased, decodeRedemption(w.Body.Bytes()).Status)
+	assert.Equal(t, int64(137), ledger.balance("Customer1"))
+
+	assert.Equal(t, http.StatusConflict, serveJSON(router, "POST", "/redemptions/Hold1/capture", nil).Code)
+	w = serveJSON(router, "GET", "/redemptions/Hold1", nil)
+	assert.Equal(t, redemptionReleased, decodeRedemption(w.Body.Bytes()).Status)
+
+	teardown()
+}
+
+// TestRedemptionInvalid
+func TestRedemptionInvalid(t *testing.T) {
+	setup()
+	router := newCustomerWithPoints(t)
+
+	w := serveJSON(router, "POST", "/customers/Customer1/redemptions", CreateRedemptionRequest{Points: -5})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "POST", "/customers/Customer2/redemptions", CreateRedemptionRequest{Points: 5}).Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "POST", "/redemptions/Hold7/capture", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "GET", "/redemptions/Hold7", nil).Code)
+
+	teardown()
+}
+
+// TestDeletedReceiptReversesPoints
+func TestDeletedReceiptReversesPoints(t *testing.T) {
+	setup()
+	router := newCustomerWithPoints(t)
+
+	w := serveJSON(router, "DELETE", "/receipts/Receipt2", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "GET", "/receipts/Receipt2/points", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "DELETE", "/receipts/Receipt2", nil).Code)
+
+	entries := ledger.entriesFor("Customer1")
+	assert.Len(t, entries, 3)
+	assert.Equal(t, entryReversal, entries[2].Type)
+	assert.Equal(t, int64(-109), entries[2].Points)
+	assert.Equal(t, "Receipt2", entries[2].ReceiptID)
+	assert.Equal(t, int64(28), ledger.balance("Customer1"))
+
+	teardown()
+}
+
+// TestCorrectedReceiptReversesAndCredits
+func TestCorrectedReceiptReversesAndCredits(t *testing.T) {
+	setup()
+	router := newCustomerWithPoints(t)
+
+	corrected := validReceipt3
+	corrected.CustomerID = "Customer1"
+	w := serveJSON(router, "PUT", "/receipts/Receipt1", corrected)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	entries := ledger.entriesFor("Customer1")
+	assert.Len(t, entries, 4)
+	assert.Equal(t, entryReversal, entries[2].Type)
+	assert.Equal(t, int64(-28), entries[2].Points)
+	assert.Equal(t, entryEarn, entries[3].Type)
+	assert.Equal(t, int64(62), entries[3].Points)
+	assert.Equal(t, int64(109+62), ledger.balance("Customer1"))
+
+	// An invalid correction leaves the receipt as it was
+	assert.Equal(t, http.StatusBadRequest, serveJSON(router, "PUT", "/receipts/Receipt1", receiptInvalidTotal).Code)
+	assert.Equal(t, "Target-Kroger", receiptsMap["Receipt1"].Retailer)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "PUT", "/receipts/Receipt9", corrected).Code)
+
+	teardown()
+}
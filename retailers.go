@@ -0,0 +1,381 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How a printed retailer name was linked to a retailer of the registry
+const (
+	matchedName    = "name"
+	matchedAlias   = "alias"
+	matchedPattern = "pattern"
+	matchedNone    = "none"
+)
+
+// Retailer is a canonical retailer of the registry, receipts are linked to it when they are processed
+type Retailer struct {
+	ID   string `json:"id"`
+	Name string `json:"name" binding:"required,max=256"`
+	// Aliases are other names of the retailer. The name and the aliases are matched ignoring case,
+	// extra spaces and a store number at the end, so "TARGET #1234" matches "Target"
+	Aliases []string `json:"aliases,omitempty" binding:"max=100,dive,required,max=256"`
+	// Patterns are regular expressions matched with the printed name, ignoring case, like "^target\\b"
+	Patterns []string `json:"patterns,omitempty" binding:"max=100,dive,required,max=256"`
+	// TimeZone is the IANA time zone of the retailer's stores
+	TimeZone string `json:"timeZone,omitempty"`
+	Category string `json:"category,omitempty" binding:"max=256"`
+	// Tenant is the tenant of the admin that added the retailer, only that tenant's receipts are linked to it
+	Tenant string `json:"tenant,omitempty"`
+
+	// compiled are the compiled Patterns, set by validate
+	compiled []*regexp.Regexp
+}
+
+// A store number at the end of a printed name, like "#1234" or "# 12"
+var storeNumberPattern = regexp.MustCompile(`\s*#\s*\d+$`)
+
+// retailerKey is the form names and aliases are compared in, see Retailer.Aliases
+func retailerKey(name string) string {
+	return normalizeRetailer(storeNumberPattern.ReplaceAllString(normalizeRetailer(name), ""))
+}
+
+// validate checks the time zone and compiles the patterns
+func (retailer *Retailer) validate() error {
+	if retailerKey(retailer.Name) == "" {
+		return errors.New("the name needs more than a store number")
+	}
+	if _, err := loadTimeZone(retailer.TimeZone); err != nil {
+		return err
+	}
+	retailer.compiled = nil
+	for _, pattern := range retailer.Patterns {
+		compiled, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return fmt.Errorf("the pattern %q is not a valid regular expression", pattern)
+		}
+		retailer.compiled = append(retailer.compiled, compiled)
+	}
+	return nil
+}
+
+// keys returns the compared forms of the name and the aliases
+func (retailer Retailer) keys() []string {
+	keys := []string{retailerKey(retailer.Name)}
+	for _, alias := range retailer.Aliases {
+		keys = append(keys, retailerKey(alias))
+	}
+	return keys
+}
+
+// retailerStore keeps the retailer registry in memory
+type retailerStore struct {
+	mutex     sync.RWMutex
+	retailers map[string]Retailer
+	lastID    int
+}
+
+// newRetailerStore creates an empty registry
+func newRetailerStore() *retailerStore {
+	return &retailerStore{retailers: make(map[string]Retailer)}
+}
+
+// Stores the retailer registry in memory
+var retailers *retailerStore = newRetailerStore()
+
+// list returns the retailers ordered by id
+func (s *retailerStore) list() []Retailer {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	list := make([]Retailer, 0, len(s.retailers))
+	for _, retailer := range s.retailers {
+		list = append(list, retailer)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		return idNumber(list[a].ID, "Retailer") < idNumber(list[b].ID, "Retailer")
+	})
+	return list
+}
+
+// get returns the retailer if it exists and belongs to the tenant, the retailers of other tenants are not found
+func (s *retailerStore) get(id string, tenant string) (Retailer, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	retailer, ok := s.retailers[id]
+	if !ok || retailer.Tenant != tenant {
+		return Retailer{}, false
+	}
+	return retailer, true
+}
+
+// listFor returns the retailers of the tenant ordered by id
+func (s *retailerStore) listFor(tenant string) []Retailer {
+	list := []Retailer{}
+	for _, retailer := range s.list() {
+		if retailer.Tenant == tenant {
+			list = append(list, retailer)
+		}
+	}
+	return list
+}
+
+// match links a printed name to a retailer of the tenant. A name or alias comes first, then the patterns
+// of the retailers in the order they were added
+func (s *retailerStore) match(printed string, tenant string) (Retailer, string) {
+	key := retailerKey(printed)
+	list := s.listFor(tenant)
+	for _, retailer := range list {
+		for i, name := range retailer.keys() {
+			if name != key {
+				continue
+			}
+			if i == 0 {
+				return retailer, matchedName
+			}
+			return retailer, matchedAlias
+		}
+	}
+	for _, retailer := range list {
+		for _, pattern := range retailer.compiled {
+			if pattern.MatchString(printed) {
+				return retailer, matchedPattern
+			}
+		}
+	}
+	return Retailer{}, matchedNone
+}
+
+// conflict returns the id of another retailer of the same tenant that already has one of the names or aliases
+func (s *retailerStore) conflict(retailer Retailer) (string, string, bool) {
+	for _, other := range s.retailers {
+		if other.ID == retailer.ID || other.Tenant != retailer.Tenant {
+			continue
+		}
+		for _, key := range retailer.keys() {
+			for _, otherKey := range other.keys() {
+				if key == otherKey {
+					return key, other.ID, true
+				}
+			}
+		}
+	}
+	return "", "", false
+}
+
+// save adds or replaces the retailer, failing if another retailer has one of its names or aliases
+func (s *retailerStore) save(retailer Retailer) (Retailer, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if key, id, ok := s.conflict(retailer); ok {
+		return retailer, fmt.Errorf("the name %q is already used by %s", key, id)
+	}
+	if retailer.ID == "" {
+		s.lastID += 1
+		retailer.ID = "Retailer" + strconv.Itoa(s.lastID)
+	}
+	s.retailers[retailer.ID] = retailer
+	return retailer, nil
+}
+
+// linkRetailer links the receipt to the retailer of its tenant that its printed name matches, the link is
+// kept when the registry changes later
+func linkRetailer(receipt *Receipt) {
+	retailer, _ := retailers.match(receipt.Retailer, receipt.Tenant)
+	receipt.RetailerID = retailer.ID
+	receipt.RetailerName = retailer.Name
+}
+
+// canonicalRetailer returns the name of the retailer the receipt is linked to, or the printed name when
+// it is not linked. The rule overrides, campaigns, filters and time zones use it
+func (receipt Receipt) canonicalRetailer() string {
+	if receipt.RetailerName != "" {
+		return receipt.RetailerName
+	}
+	return receipt.Retailer
+}
+
+// sameRetailer reports whether the receipt is from the retailer, by the printed or the canonical name,
+// ignoring case and surrounding spaces
+func (receipt Receipt) sameRetailer(retailer string) bool {
+	retailer = strings.TrimSpace(retailer)
+	return strings.EqualFold(strings.TrimSpace(receipt.Retailer), retailer) ||
+		strings.EqualFold(strings.TrimSpace(receipt.RetailerName), retailer)
+}
+
+// RetailerMatchRequest is a printed name to link to the registry
+type RetailerMatchRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// RetailerMatch is the retailer a printed name is linked to
+type RetailerMatch struct {
+	Name       string `json:"name"`
+	RetailerID string `json:"retailerId,omitempty"`
+	// Canonical is the name of the retailer in the registry
+	Canonical string `json:"canonical,omitempty"`
+	// MatchedBy is name, alias, pattern or none
+	MatchedBy string `json:"matchedBy"`
+}
+
+// RetailerSummary is the receipts and points of the caller for one retailer
+type RetailerSummary struct {
+	// RetailerID is empty for receipts that are not linked, they are grouped by their normalized printed name
+	RetailerID string `json:"retailerId,omitempty"`
+	Name       string `json:"name"`
+	Category   string `json:"category,omitempty"`
+	Receipts   int    `json:"receipts"`
+	Points     int64  `json:"points"`
+}
+
+// bindRetailer reads and validates the retailer in the request body, writing the 400 response if it is invalid
+func bindRetailer(c *gin.Context) (Retailer, bool) {
+	var retailer Retailer
+	if err := c.ShouldBindJSON(&retailer); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusBadRequest, "The retailer is invalid.")
+		return retailer, false
+	}
+	if err := retailer.validate(); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusBadRequest, "The retailer is invalid: "+err.Error()+".")
+		return retailer, false
+	}
+	return retailer, true
+}
+
+// saveRetailer stores the retailer, writing the 409 response if its names are taken
+func saveRetailer(c *gin.Context, retailer Retailer) {
+	retailer, err := retailers.save(retailer)
+	if err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusConflict, "The retailer is invalid: "+err.Error()+".")
+		return
+	}
+	c.JSON(http.StatusOK, retailer)
+}
+
+// createRetailer adds a retailer to the registry
+func createRetailer(c *gin.Context) {
+	retailer, ok := bindRetailer(c)
+	if !ok {
+		return
+	}
+	retailer.Tenant = requestTenant(c)
+	saveRetailer(c, retailer)
+}
+
+// listRetailers returns the registry of the tenant, only the retailers in the category query parameter when it is given
+func listRetailers(c *gin.Context) {
+	list := []Retailer{}
+	for _, retailer := range retailers.listFor(requestTenant(c)) {
+		if category := c.Query("category"); category == "" || strings.EqualFold(retailer.Category, category) {
+			list = append(list, retailer)
+		}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// getRetailer returns one retailer
+func getRetailer(c *gin.Context) {
+	retailer, ok := retailers.get(c.Param("id"), requestTenant(c))
+	if !ok {
+		c.String(http.StatusNotFound, "No retailer found for that ID.")
+		return
+	}
+	c.JSON(http.StatusOK, retailer)
+}
+
+// updateRetailer replaces a retailer, receipts that were already linked keep their link
+func updateRetailer(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := retailers.get(id, requestTenant(c)); !ok {
+		c.String(http.StatusNotFound, "No retailer found for that ID.")
+		return
+	}
+	retailer, ok := bindRetailer(c)
+	if !ok {
+		return
+	}
+	retailer.ID = id
+	retailer.Tenant = requestTenant(c)
+	saveRetailer(c, retailer)
+}
+
+// deleteRetailer removes a retailer from the registry
+func deleteRetailer(c *gin.Context) {
+	retailers.mutex.Lock()
+	retailer, ok := retailers.retailers[c.Param("id")]
+	ok = ok && retailer.Tenant == requestTenant(c)
+	if ok {
+		delete(retailers.retailers, c.Param("id"))
+	}
+	retailers.mutex.Unlock()
+	if !ok {
+		c.String(http.StatusNotFound, "No retailer found for that ID.")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// matchRetailer returns the retailer a printed name would be linked to
+func matchRetailer(c *gin.Context) {
+	var request RetailerMatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusBadRequest, "The retailer name is invalid.")
+		return
+	}
+	retailer, matchedBy := retailers.match(request.Name, requestTenant(c))
+	c.JSON(http.StatusOK, RetailerMatch{
+		Name:       request.Name,
+		RetailerID: retailer.ID,
+		Canonical:  retailer.Name,
+		MatchedBy:  matchedBy,
+	})
+}
+
+// summarizeRetailers returns the caller's receipts and points by canonical retailer, most points first
+func summarizeRetailers(c *gin.Context) {
+	owner := requestOwner(c)
+	summaries := make(map[string]*RetailerSummary)
+	receiptsMutex.RLock()
+	for _, receipt := range receiptsMap {
+		if receipt.Owner != owner {
+			continue
+		}
+		// Receipts linked to a retailer that was deleted since are grouped like the ones that are not linked
+		key := "name:" + normalizeRetailer(receipt.Retailer)
+		summary := RetailerSummary{Name: normalizeRetailer(receipt.Retailer)}
+		if retailer, ok := retailers.get(receipt.RetailerID, receipt.Tenant); ok {
+			key = "id:" + retailer.ID
+			summary = RetailerSummary{RetailerID: retailer.ID, Name: retailer.Name, Category: retailer.Category}
+		}
+		if summaries[key] == nil {
+			summaries[key] = &summary
+		}
+		summaries[key].Receipts++
+		summaries[key].Points += receiptPoints(receipt)
+	}
+	receiptsMutex.RUnlock()
+
+	list := make([]RetailerSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		list = append(list, *summary)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		if list[a].Points != list[b].Points {
+			return list[a].Points > list[b].Points
+		}
+		return list[a].Name < list[b].Name
+	})
+	c.JSON(http.StatusOK, list)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// createTestRetailer adds the retailer through the API and returns it as stored
+func createTestRetailer(t *testing.T, router *gin.Engine, retailer Retailer) Retailer {
+	w := serveJSON(router, "POST", "/retailers", retailer)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /retailers = %d %s", w.Code, w.Body.String())
+	}
+	var created Retailer
+	json.Unmarshal(w.Body.Bytes(), &created)
+	return created
+}
+
+// newRetailerConfig returns a config that allows # in the retailer names, for the store numbers
+func newRetailerConfig() Config {
+	cfg := defaultConfig()
+	cfg.Text = TextConfig{Policy: textPolicyUnicode, Punctuation: "#"}
+	return cfg
+}
+
+// TestRetailerRegistry
+func TestRetailerRegistry(t *testing.T) {
+	setup()
+	router := newTestRouter(t, newRetailerConfig())
+	target := createTestRetailer(t, router, Retailer{Name: "Target", Aliases: []string{"Target Stores"}, Category: "general"})
+	kroger := createTestRetailer(t, router, Retailer{Name: "Kroger", Patterns: []string{`^kroger\b`}, Category: "grocery"})
+	assert.Equal(t, "Retailer1", target.ID)
+	assert.Equal(t, "Retailer2", kroger.ID)
+
+	tests := map[string]RetailerMatch{
+		"  TARGET  #1234":    {RetailerID: "Retailer1", Canonical: "Target", MatchedBy: "name"},
+		"target stores":      {RetailerID: "Retailer1", Canonical: "Target", MatchedBy: "alias"},
+		"Kroger Marketplace": {RetailerID: "Retailer2", Canonical: "Kroger", MatchedBy: "pattern"},
+		"Target-Kroger":      {MatchedBy: "none"},
+	}
+	for name, expected := range tests {
+		var match RetailerMatch
+		json.Unmarshal(serveJSON(router, "POST", "/retailers/match", RetailerMatchRequest{Name: name}).Body.Bytes(), &match)
+		expected.Name = name
+		assert.Equal(t, expected, match, name)
+	}
+
+	// Names and aliases can only be used by one retailer
+	w := serveJSON(router, "POST", "/retailers", Retailer{Name: "Tgt", Aliases: []string{"TARGET #9"}})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, `The retailer is invalid: the name "target" is already used by Retailer1.`, w.Body.String())
+	invalid := map[string]Retailer{
+		"The retailer is invalid: unknown time zone Mars/Base.":                       {Name: "Walmart", TimeZone: "Mars/Base"},
+		`The retailer is invalid: the pattern "(" is not a valid regular expression.`: {Name: "Walmart", Patterns: []string{"("}},
+		"The retailer is invalid.":                                                    {Aliases: []string{"Walmart"}},
+	}
+	for expected, retailer := range invalid {
+		w := serveJSON(router, "POST", "/retailers", retailer)
+		assert.Equal(t, http.StatusBadRequest, w.Code, expected)
+		assert.Equal(t, expected, w.Body.String())
+	}
+
+	var list []Retailer
+	json.Unmarshal(serveJSON(router, "GET", "/retailers?category=Grocery", nil).Body.Bytes(), &list)
+	assert.Equal(t, []Retailer{kroger}, list)
+
+	// An update keeps the id, and frees the names it no longer has
+	target.Aliases = nil
+	assert.Equal(t, http.StatusOK, serveJSON(router, "PUT", "/retailers/Retailer1", target).Code)
+	var updated Retailer
+	json.Unmarshal(serveJSON(router, "GET", "/retailers/Retailer1", nil).Body.Bytes(), &updated)
+	assert.Equal(t, target, updated)
+	createTestRetailer(t, router, Retailer{Name: "Target Stores"})
+
+	assert.Equal(t, http.StatusNoContent, serveJSON(router, "DELETE", "/retailers/Retailer2", nil).Code)
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		w := serveJSON(router, method, "/retailers/Retailer2", kroger)
+		assert.Equal(t, http.StatusNotFound, w.Code, method)
+		assert.Equal(t, "No retailer found for that ID.", w.Body.String(), method)
+	}
+
+	teardown()
+}
+
+// TestReceiptRetailerLink
+func TestReceiptRetailerLink(t *testing.T) {
+	setup()
+	cfg := newRetailerConfig()
+	cfg.RulesFile = writeOverridesFile(t)
+	router := newTestRouter(t, cfg)
+	createTestRetailer(t, router, Retailer{Name: "Target", TimeZone: "America/Chicago", Category: "general"})
+
+	receipt := validReceipt1
+	receipt.Retailer = "TARGET #1234"
+	var created ReceiptCreatedResponse
+	json.Unmarshal(serveJSON(router, "POST", "/receipts/process", receipt).Body.Bytes(), &created)
+	stored := receiptsMap[created.ID]
+	assert.Equal(t, "Retailer1", stored.RetailerID)
+	assert.Equal(t, "Target", stored.RetailerName)
+	assert.Equal(t, "America/Chicago", stored.PurchasedAt.Location().String())
+	// The printed name is scored, with four more characters than validReceipt1, by the override for Target
+	assert.Equal(t, int64(32), stored.Breakdown.Total)
+	assert.Equal(t, "2", stored.Breakdown.RuleVersion)
+
+	serveJSON(router, "POST", "/receipts/process", validReceipt1)
+	serveJSON(router, "POST", "/receipts/process", validReceipt2)
+
+	var summary []RetailerSummary
+	json.Unmarshal(serveJSON(router, "GET", "/retailers/summary", nil).Body.Bytes(), &summary)
+	assert.Equal(t, []RetailerSummary{
+		{Name: "m&m corner market", Receipts: 1, Points: 109},
+		{RetailerID: "Retailer1", Name: "Target", Category: "general", Receipts: 2, Points: 60},
+	}, summary)
+
+	// Filters and campaigns match the canonical name too
+	assert.True(t, RescoreFilter{Retailer: "target"}.matches(stored))
+	assert.True(t, Campaign{Start: "2022-01-01T00:00", End: "2022-01-02T00:00", Retailer: "target"}.matches(stored))
+
+	teardown()
+}
+
+// TestTenantRetailers
+func TestTenantRetailers(t *testing.T) {
+	setup()
+	cfg := newTenantConfig()
+	cfg.Text = newRetailerConfig().Text
+	router := newTestRouter(t, cfg)
+	w := serveJSON(router, "POST", "/t/acme/retailers", Retailer{Name: "Target", TimeZone: "America/Chicago"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var target Retailer
+	json.Unmarshal(w.Body.Bytes(), &target)
+	assert.Equal(t, "acme", target.Tenant)
+
+	// Other tenants and callers without a tenant do not see the retailer, and can use its name
+	for _, prefix := range []string{"/t/globex", ""} {
+		for _, method := range []string{"GET", "PUT", "DELETE"} {
+			w := serveJSON(router, method, prefix+"/retailers/"+target.ID, target)
+			assert.Equal(t, http.StatusNotFound, w.Code, prefix+" "+method)
+		}
+		var list []Retailer
+		json.Unmarshal(serveJSON(router, "GET", prefix+"/retailers", nil).Body.Bytes(), &list)
+		assert.Empty(t, list, prefix)
+		var match RetailerMatch
+		json.Unmarshal(serveJSON(router, "POST", prefix+"/retailers/match", RetailerMatchRequest{Name: "TARGET #1234"}).Body.Bytes(), &match)
+		assert.Equal(t, "none", match.MatchedBy, prefix)
+	}
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/t/globex/retailers", Retailer{Name: "Target"}).Code)
+
+	// Receipts are only linked to the retailers of their tenant
+	tenants := map[string]string{"/t/acme": target.ID, "/t/globex": "Retailer2", "": ""}
+	for prefix, expected := range tenants {
+		var created ReceiptCreatedResponse
+		json.Unmarshal(serveJSON(router, "POST", prefix+"/receipts/process", validReceipt1).Body.Bytes(), &created)
+		assert.Equal(t, expected, receiptsMap[created.ID].RetailerID, prefix)
+	}
+
+	teardown()
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The version of the rules the service was first released with
+const defaultRuleVersion = "1"
+
+// RuleSet holds the points given by each scoring rule. A version is never changed once it is in use,
+// so the receipts scored with it can be scored again with the same result
+type RuleSet struct {
+	Version string `json:"version"`
+	// Tenant keeps the rule set to one tenant, it can only be picked by that tenant's overrides
+	Tenant string `json:"tenant,omitempty"`
+	// Points for every alphanumeric character in the retailer name
+	RetailerCharacterPoints int64 `json:"retailerCharacterPoints"`
+	// Points if the total is a round dollar amount
+	RoundDollarPoints int64 `json:"roundDollarPoints"`
+	// Points if the total is a multiple of 0.25
+	QuarterMultiplePoints int64 `json:"quarterMultiplePoints"`
+	// Points for every two items, PairCounting is "lines" to count the lines or "units" to count their quantities
+	PairPoints   int64  `json:"pairPoints"`
+	PairCounting string `json:"pairCounting"`
+	// Items with a trimmed description length that is a multiple of DescriptionLengthMultiple
+	// earn their price times DescriptionPriceMultiplier, rounded up
+	DescriptionLengthMultiple  int     `json:"descriptionLengthMultiple"`
+	DescriptionPriceMultiplier float64 `json:"descriptionPriceMultiplier"`
+	// Points if the day in the purchase date is odd
+	OddDayPoints int64 `json:"oddDayPoints"`
+	// Points if the purchase time is between AfternoonStart and AfternoonEnd, inclusive, as "15:04"
+	AfternoonPoints int64  `json:"afternoonPoints"`
+	AfternoonStart  string `json:"afternoonStart"`
+	AfternoonEnd    string `json:"afternoonEnd"`
+	// CustomRules are scored after the built-in rules
+	CustomRules []CustomRule `json:"customRules,omitempty"`
+
+	// programs are the compiled CustomRules, set by validate
+	programs []*customProgram
+}
+
+// visibleTo reports whether the tenant can see and use the rule set, the rule sets of a tenant are hidden from
+// other tenants and from requests without a tenant
+func (rules RuleSet) visibleTo(tenant string) bool {
+	return rules.Tenant == "" || rules.Tenant == tenant
+}
+
+// The names of the built-in rules in the breakdowns, custom rules can not use them
+var builtInRuleNames = []string{
+	"retailerName", "roundDollar", "multipleOfQuarter", "itemPairs", "itemDescriptionLength", "oddPurchaseDay", "afternoonPurchase",
+}
+
+// defaultRuleSet returns the rules the service was first released with
+func defaultRuleSet() RuleSet {
+	return RuleSet{
+		Version:                    defaultRuleVersion,
+		RetailerCharacterPoints:    1,
+		RoundDollarPoints:          50,
+		QuarterMultiplePoints:      25,
+		PairPoints:                 5,
+		PairCounting:               pairCountingLines,
+		DescriptionLengthMultiple:  3,
+		DescriptionPriceMultiplier: 0.2,
+		OddDayPoints:               6,
+		AfternoonPoints:            10,
+		AfternoonStart:             "14:00",
+		AfternoonEnd:               "16:00",
+	}
+}
+
+// validate checks the rule set can score a receipt and compiles its custom rules
+func (rules *RuleSet) validate() error {
+	if rules.Version == "" {
+		return errors.New("rule sets need a version")
+	}
+	if rules.DescriptionLengthMultiple <= 0 {
+		return fmt.Errorf("rule set %s: descriptionLengthMultiple must be positive", rules.Version)
+	}
+	if rules.PairCounting != pairCountingLines && rules.PairCounting != pairCountingUnits {
+		return fmt.Errorf(`rule set %s: pairCounting must be "lines" or "units"`, rules.Version)
+	}
+	if _, err := time.Parse("15:04", rules.AfternoonStart); err != nil {
+		return fmt.Errorf("rule set %s: afternoonStart must use the layout 15:04", rules.Version)
+	}
+	if _, err := time.Parse("15:04", rules.AfternoonEnd); err != nil {
+		return fmt.Errorf("rule set %s: afternoonEnd must use the layout 15:04", rules.Version)
+	}
+
+	names := make(map[string]bool)
+	for _, name := range builtInRuleNames {
+		names[name] = true
+	}
+	rules.programs = nil
+	for _, rule := range rules.CustomRules {
+		if rule.Name == "" || names[rule.Name] {
+			return fmt.Errorf("rule set %s: custom rules need a name that is not used by another rule", rules.Version)
+		}
+		names[rule.Name] = true
+		program, err := compileCustomRule(rule)
+		if err != nil {
+			return fmt.Errorf("rule set %s: %w", rules.Version, err)
+		}
+		rules.programs = append(rules.programs, program)
+	}
+	return nil
+}
+
+// ruleSetRegistry keeps every version of the rules and which one new receipts are scored with
+type ruleSetRegistry struct {
+	mutex         sync.RWMutex
+	sets          map[string]RuleSet
+	activeVersion string
+	// previous are the versions that were active before, the last one is the one a rollback goes back to
+	previous []string
+	// overrides pick another version for some tenants and retailers, see resolve
+	overrides []RuleOverride
+	// path is the rules file, fileHash the SHA-256 hash of its contents when it was loaded
+	path     string
+	fileHash string
+}
+
+// RuleOverride scores the receipts of a tenant, a retailer or both with another version than the active one
+type RuleOverride struct {
+	Tenant string `json:"tenant,omitempty"`
+	// Retailer matches the normalized retailer name, see normalizeRetailer
+	Retailer string `json:"retailer,omitempty"`
+	Version  string `json:"version"`
+}
+
+// How a rule set was picked for a receipt, from the most to the least specific
+const (
+	matchedTenantAndRetailer = "tenantAndRetailer"
+	matchedTenant            = "tenant"
+	matchedRetailer          = "retailer"
+	matchedActive            = "active"
+)
+
+// normalizeRetailer ignores case and extra spaces so "  Walgreens " and "WALGREENS" are the same retailer
+func normalizeRetailer(retailer string) string {
+	return strings.Join(strings.Fields(strings.ToLower(retailer)), " ")
+}
+
+// newRuleSetRegistry creates a registry with only the default rules
+func newRuleSetRegistry() *ruleSetRegistry {
+	rules := defaultRuleSet()
+	return &ruleSetRegistry{sets: map[string]RuleSet{rules.Version: rules}, activeVersion: rules.Version}
+}
+
+// Stores the rule sets in memory, setupRouter replaces it with the rules file from the config
+var ruleSets *ruleSetRegistry = newRuleSetRegistry()
+
+// rulesFile is the JSON layout of the rules file
+type rulesFile struct {
+	// Active is the version new receipts are scored with
+	Active    string            `json:"active"`
+	RuleSets  []json.RawMessage `json:"ruleSets"`
+	Overrides []RuleOverride    `json:"overrides"`
+}
+
+// loadRuleSets reads the rule sets in the file at path, the default rules are always kept
+// so the receipts scored with them can still be scored again
+func loadRuleSets(path string) (*ruleSetRegistry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file rulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	registry := newRuleSetRegistry()
+	registry.path = path
+	registry.fileHash = contentHash(data)
+	for _, raw := range file.RuleSets {
+		// Rules that are left out keep their default points
+		rules := defaultRuleSet()
+		rules.Version = ""
+		if err := json.Unmarshal(raw, &rules); err != nil {
+			return nil, err
+		}
+		if err := rules.validate(); err != nil {
+			return nil, err
+		}
+		if existing, ok := registry.sets[rules.Version]; ok && !sameRules(existing, rules) {
+			return nil, fmt.Errorf("rule set %s is listed twice or changes the default rules", rules.Version)
+		}
+		registry.sets[rules.Version] = rules
+	}
+	if file.Active != "" {
+		if _, ok := registry.sets[file.Active]; !ok {
+			return nil, fmt.Errorf("the active rule set %s is not in the file", file.Active)
+		}
+		if tenant := registry.sets[file.Active].Tenant; tenant != "" {
+			return nil, fmt.Errorf("the active rule set %s is only for tenant %s", file.Active, tenant)
+		}
+		registry.activeVersion = file.Active
+	}
+	seen := make(map[RuleOverride]bool)
+	for _, override := range file.Overrides {
+		if override.Tenant == "" && override.Retailer == "" {
+			return nil, errors.New("rule overrides need a tenant, a retailer or both")
+		}
+		rules, ok := registry.sets[override.Version]
+		if !ok {
+			return nil, fmt.Errorf("the rule set %s of an override is not in the file", override.Version)
+		}
+		if rules.Tenant != "" && rules.Tenant != override.Tenant {
+			return nil, fmt.Errorf("the rule set %s is only for tenant %s", override.Version, rules.Tenant)
+		}
+		override.Retailer = normalizeRetailer(override.Retailer)
+		key := RuleOverride{Tenant: override.Tenant, Retailer: override.Retailer}
+		if seen[key] {
+			return nil, fmt.Errorf("the override for tenant %q and retailer %q is listed twice", override.Tenant, override.Retailer)
+		}
+		seen[key] = true
+		registry.overrides = append(registry.overrides, override)
+	}
+	return registry, nil
+}
+
+// reload loads the rules file again and swaps it in, the current rules are kept if the file is invalid
+func (r *ruleSetRegistry) reload() error {
+	r.mutex.RLock()
+	path := r.path
+	r.mutex.RUnlock()
+	if path == "" {
+		return errors.New("no rules file is configured")
+	}
+	loaded, err := loadRuleSets(path)
+	if err != nil {
+		return err
+	}
+	return r.swap(loaded)
+}
+
+// swap replaces the rule sets with the loaded ones in one step. Versions that are no longer in the file are
+// kept so the receipts scored with them can still be scored again, and a version can not be changed
+func (r *ruleSetRegistry) swap(loaded *ruleSetRegistry) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for version, rules := range loaded.sets {
+		if existing, ok := r.sets[version]; ok && !sameRules(existing, rules) {
+			return fmt.Errorf("rule set %s can not be changed, add a new version instead", version)
+		}
+	}
+	sets := make(map[string]RuleSet, len(r.sets))
+	for version, rules := range r.sets {
+		sets[version] = rules
+	}
+	for version, rules := range loaded.sets {
+		sets[version] = rules
+	}
+	r.sets = sets
+	if loaded.activeVersion != r.activeVersion {
+		r.previous = append(r.previous, r.activeVersion)
+		r.activeVersion = loaded.activeVersion
+	}
+	r.overrides = loaded.overrides
+	r.fileHash = loaded.fileHash
+	return nil
+}
+
+// rollback makes the version that was active before the current one active again
+func (r *ruleSetRegistry) rollback() (string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.previous) == 0 {
+		return r.activeVersion, false
+	}
+	r.activeVersion = r.previous[len(r.previous)-1]
+	r.previous = r.previous[:len(r.previous)-1]
+	return r.activeVersion, true
+}
+
+// watch reloads the rules file whenever its contents change, checking every interval
+func (r *ruleSetRegistry) watch(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	r.mutex.RLock()
+	lastHash := r.fileHash
+	r.mutex.RUnlock()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data, err := os.ReadFile(r.path)
+			if err != nil || contentHash(data) == lastHash {
+				continue
+			}
+			// An invalid file is only reported once, until it changes again
+			lastHash = contentHash(data)
+			r.reloadAndLog(logger, "file")
+		}
+	}
+}
+
+// reloadAndLog reloads the rules file and logs the outcome, trigger is what asked for the reload
+func (r *ruleSetRegistry) reloadAndLog(logger *slog.Logger, trigger string) error {
+	if err := r.reload(); err != nil {
+		logger.Error("unable to reload rules", slog.String("trigger", trigger), slog.Any("error", err))
+		return err
+	}
+	logger.Info("reloaded rules", slog.String("trigger", trigger), slog.String("ruleVersion", r.active().Version))
+	return nil
+}
+
+// contentHash returns the hex SHA-256 hash of the file contents
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sameRules reports whether the two rule sets give the same points
+func sameRules(a RuleSet, b RuleSet) bool {
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	return bytes.Equal(aJSON, bJSON)
+}
+
+// active returns the rules new receipts are scored with
+func (r *ruleSetRegistry) active() RuleSet {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.sets[r.activeVersion]
+}
+
+// resolve returns the rules a receipt of the tenant and retailer is scored with and how they were picked.
+// An override for both the tenant and the retailer comes first, then one for the tenant, then one for the
+// retailer, and the active rules when none match
+func (r *ruleSetRegistry) resolve(tenant string, retailer string) (RuleSet, string) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	retailer = normalizeRetailer(retailer)
+	// Requests without a tenant only match the retailer overrides
+	if tenant != "" {
+		if version, ok := r.override(tenant, retailer); ok {
+			return r.sets[version], matchedTenantAndRetailer
+		}
+		if version, ok := r.override(tenant, ""); ok {
+			return r.sets[version], matchedTenant
+		}
+	}
+	if version, ok := r.override("", retailer); ok {
+		return r.sets[version], matchedRetailer
+	}
+	return r.sets[r.activeVersion], matchedActive
+}
+
+// override returns the version of the override for exactly the tenant and retailer
+func (r *ruleSetRegistry) override(tenant string, retailer string) (string, bool) {
+	for _, override := range r.overrides {
+		if override.Tenant == tenant && override.Retailer == retailer {
+			return override.Version, true
+		}
+	}
+	return "", false
+}
+
+// listOverrides returns the rule overrides in the order of the rules file
+func (r *ruleSetRegistry) listOverrides() []RuleOverride {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return slices.Clone(r.overrides)
+}
+
+// get returns the rules with the version
+func (r *ruleSetRegistry) get(version string) (RuleSet, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	rules, ok := r.sets[version]
+	return rules, ok
+}
+
+// list returns every rule set ordered by version
+func (r *ruleSetRegistry) list() []RuleSet {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	list := make([]RuleSet, 0, len(r.sets))
+	for _, rules := range r.sets {
+		list = append(list, rules)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].Version < list[b].Version
+	})
+	return list
+}
+
+type RuleSetsResponse struct {
+	Active    string         `json:"active"`
+	RuleSets  []RuleSet      `json:"ruleSets"`
+	Overrides []RuleOverride `json:"overrides"`
+}
+
+// RuleResolution shows which rule set applies to a receipt and why
+type RuleResolution struct {
+	RuleVersion string `json:"ruleVersion"`
+	Tenant      string `json:"tenant,omitempty"`
+	// Retailer is the normalized retailer the overrides are matched with
+	Retailer string `json:"retailer"`
+	// MatchedBy is tenantAndRetailer, tenant, retailer or active
+	MatchedBy string `json:"matchedBy"`
+}
+
+// RescoreResponse compares the points a receipt was awarded with the points it gets under another rule set
+type RescoreResponse struct {
+	ReceiptID string           `json:"receiptId"`
+	Recorded  *PointsBreakdown `json:"recorded"`
+	Rescored  *PointsBreakdown `json:"rescored"`
+	// Difference is the rescored total minus the recorded total
+	Difference int64 `json:"difference"`
+}
+
+// reportRuleVersion adds the active rule version to every response
+func reportRuleVersion(c *gin.Context) {
+	c.Header("X-Rule-Version", ruleSets.active().Version)
+	c.Next()
+}
+
+// reloadRuleSets loads the rules file again
+func reloadRuleSets(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := ruleSets.reloadAndLog(logger, "api"); err != nil {
+			c.String(http.StatusInternalServerError, "Unable to reload the rules: "+err.Error()+".")
+			return
+		}
+		// The header was set before the reload
+		c.Header("X-Rule-Version", ruleSets.active().Version)
+		listRuleSets(c)
+	}
+}
+
+// rollbackRuleSets makes the previously active rule set active again
+func rollbackRuleSets(c *gin.Context) {
+	version, ok := ruleSets.rollback()
+	if !ok {
+		c.String(http.StatusConflict, "There is no previous rule set to roll back to.")
+		return
+	}
+	c.Header("X-Rule-Version", version)
+	listRuleSets(c)
+}
+
+// listRuleSets returns the active version and the rule sets and overrides the tenant of the request can see
+func listRuleSets(c *gin.Context) {
+	tenant := requestTenant(c)
+	response := RuleSetsResponse{Active: ruleSets.active().Version, RuleSets: []RuleSet{}, Overrides: []RuleOverride{}}
+	for _, rules := range ruleSets.list() {
+		if rules.visibleTo(tenant) {
+			response.RuleSets = append(response.RuleSets, rules)
+		}
+	}
+	for _, override := range ruleSets.listOverrides() {
+		if override.Tenant == "" || override.Tenant == tenant {
+			response.Overrides = append(response.Overrides, override)
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// resolveRuleSet returns which rule set the receipt in the body would be scored with
+func resolveRuleSet(c *gin.Context) {
+	receipt, ok := readReceipt(c)
+	if !ok {
+		return
+	}
+	rules, matchedBy := ruleSets.resolve(receipt.Tenant, receipt.canonicalRetailer())
+	c.JSON(http.StatusOK, RuleResolution{
+		RuleVersion: rules.Version,
+		Tenant:      receipt.Tenant,
+		Retailer:    normalizeRetailer(receipt.canonicalRetailer()),
+		MatchedBy:   matchedBy,
+	})
+}
+
+// rescoreReceiptHandler scores a receipt again with the rule set in the version parameter,
+// by default the one that applies to the receipt now
+func rescoreReceiptHandler(c *gin.Context) {
+	var receiptId = c.Param("id")
+	c.Set(receiptIDKey, receiptId)
+	receipt, ok := findReceipt(receiptId, requestOwner(c))
+	if !ok {
+		c.String(http.StatusNotFound, "No receipt found for that ID.")
+		return
+	}
+	rules, _ := ruleSets.resolve(receipt.Tenant, receipt.canonicalRetailer())
+	if version := c.Query("version"); version != "" {
+		if rules, ok = ruleSets.get(version); !ok || !rules.visibleTo(receipt.Tenant) {
+			c.String(http.StatusNotFound, "No rule set found for that version.")
+			return
+		}
+	}
+
+	recorded := receipt.Breakdown
+	if recorded == nil {
+		recorded = baseBreakdown(receipt)
+	}
+	rescored := rescoreReceipt(receipt, rules)
+	c.JSON(http.StatusOK, RescoreResponse{
+		ReceiptID:  receiptId,
+		Recorded:   recorded,
+		Rescored:   rescored,
+		Difference: rescored.Total - recorded.Total,
+	})
+}
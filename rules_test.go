@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeRulesFile writes a rules file with a version 2 that doubles the round dollar and pair points
+func writeRulesFile(t *testing.T, active string) string {
+	data := `{"active": "` + active + `", "ruleSets": [{"version": "2", "roundDollarPoints": 100, "pairPoints": 10}]}`
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestDefaultRuleSetMatchesRules
+func TestDefaultRuleSetMatchesRules(t *testing.T) {
+	rules := defaultRuleSet()
+	assert.NoError(t, rules.validate())
+	var points int64
+	for _, rule := range rules.rulePoints(validReceipt1) {
+		points += rule.Points
+	}
+	assert.Equal(t, int64(28), points)
+}
+
+// TestLoadRuleSets
+func TestLoadRuleSets(t *testing.T) {
+	registry, err := loadRuleSets(writeRulesFile(t, "2"))
+	assert.NoError(t, err)
+	assert.Equal(t, "2", registry.active().Version)
+	rules, ok := registry.get("2")
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), rules.RoundDollarPoints)
+	// Rules left out of the file keep their default points
+	assert.Equal(t, int64(25), rules.QuarterMultiplePoints)
+	_, ok = registry.get(defaultRuleVersion)
+	assert.True(t, ok)
+
+	invalid := map[string]string{
+		"unknown active":   `{"active": "3", "ruleSets": []}`,
+		"no version":       `{"ruleSets": [{"pairPoints": 10}]}`,
+		"changes default":  `{"ruleSets": [{"version": "1", "pairPoints": 10}]}`,
+		"bad afternoon":    `{"ruleSets": [{"version": "2", "afternoonStart": "2pm"}]}`,
+		"no length factor": `{"ruleSets": [{"version": "2", "descriptionLengthMultiple": 0}]}`,
+	}
+	for name, data := range invalid {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		os.WriteFile(path, []byte(data), 0600)
+		if _, err := loadRuleSets(path); err == nil {
+			t.Fatalf("loadRuleSets(%s) returned no error", name)
+		}
+	}
+}
+
+// TestRuleVersionIsRecorded
+func TestRuleVersionIsRecorded(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.RulesFile = writeRulesFile(t, "1")
+	router := newTestRouter(t, cfg)
+
+	serveJSON(router, "POST", "/receipts/process", validReceipt2)
+	w := serveJSON(router, "GET", "/receipts/Receipt1/points", nil)
+	expectedResponse, _ := json.Marshal(PointsGeneratedResponse{Points: 109, RuleVersion: "1"})
+	assert.Equal(t, string(expectedResponse), w.Body.String())
+
+	// Rolling out version 2 does not change the points of the receipts that were already scored
+	cfg.RulesFile = writeRulesFile(t, "2")
+	router = newTestRouter(t, cfg)
+	w = serveJSON(router, "GET", "/receipts/Receipt1/points", nil)
+	assert.Equal(t, string(expectedResponse), w.Body.String())
+
+	serveJSON(router, "POST", "/receipts/process", validReceipt2)
+	w = serveJSON(router, "GET", "/receipts/Receipt2/points", nil)
+	expectedResponse, _ = json.Marshal(PointsGeneratedResponse{Points: 169, RuleVersion: "2"})
+	assert.Equal(t, string(expectedResponse), w.Body.String())
+
+	var list RuleSetsResponse
+	json.Unmarshal(serveJSON(router, "GET", "/rulesets", nil).Body.Bytes(), &list)
+	assert.Equal(t, "2", list.Active)
+	assert.Len(t, list.RuleSets, 2)
+
+	teardown()
+}
+
+// TestRescoreReceipt
+func TestRescoreReceipt(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.RulesFile = writeRulesFile(t, "1")
+	router := newTestRouter(t, cfg)
+	serveJSON(router, "POST", "/receipts/process", validReceipt2)
+
+	var response RescoreResponse
+	w := serveJSON(router, "GET", "/receipts/Receipt1/rescore?version=2", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, "1", response.Recorded.RuleVersion)
+	assert.Equal(t, int64(109), response.Recorded.Total)
+	assert.Equal(t, "2", response.Rescored.RuleVersion)
+	assert.Equal(t, int64(169), response.Rescored.Total)
+	assert.Equal(t, int64(60), response.Difference)
+
+	// Scoring again with the recorded version gives the recorded points
+	json.Unmarshal(serveJSON(router, "GET", "/receipts/Receipt1/rescore?version=1", nil).Body.Bytes(), &response)
+	assert.Equal(t, int64(0), response.Difference)
+
+	w = serveJSON(router, "GET", "/receipts/Receipt1/rescore?version=9", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "No rule set found for that version.", w.Body.String())
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "GET", "/receipts/Receipt9/rescore", nil).Code)
+
+	teardown()
+}
+
+// TestRescoreKeepsAwardedCampaigns
+func TestRescoreKeepsAwardedCampaigns(t *testing.T) {
+	setup()
+	campaigns.campaigns["Campaign1"] = Campaign{ID: "Campaign1", Name: "Double", Start: "2022-01-01T00:00", End: "2022-12-31T23:59", Multiplier: 2}
+	receipt := validReceipt2
+	receipt.Breakdown = scoreReceipt(receipt)
+	assert.Equal(t, int64(218), receipt.Breakdown.Total)
+
+	// The campaign is applied as it was when the receipt was accepted, even after it is removed
+	delete(campaigns.campaigns, "Campaign1")
+	rules := defaultRuleSet()
+	rules.Version = "2"
+	rules.RoundDollarPoints = 100
+	rules.PairPoints = 10
+	rescored := rescoreReceipt(receipt, rules)
+	assert.Equal(t, int64(169), rescored.Base)
+	assert.Equal(t, []CampaignBonus{{CampaignID: "Campaign1", Name: "Double", Points: 169}}, rescored.Campaigns)
+	assert.Equal(t, int64(338), rescored.Total)
+
+	teardown()
+}
+
+// TestReloadAndRollbackRuleSets
+func TestReloadAndRollbackRuleSets(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.RulesFile = writeRulesFile(t, "1")
+	router := newTestRouter(t, cfg)
+
+	w := serveJSON(router, "GET", "/rulesets", nil)
+	assert.Equal(t, "1", w.Header().Get("X-Rule-Version"))
+	w = serveJSON(router, "POST", "/rulesets/rollback", nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "There is no previous rule set to roll back to.", w.Body.String())
+
+	// Version 3 is added and made active, version 2 is no longer in the file but is kept
+	os.WriteFile(cfg.RulesFile, []byte(`{"active": "3", "ruleSets": [{"version": "3", "oddDayPoints": 60}]}`), 0600)
+	w = serveJSON(router, "POST", "/rulesets/reload", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "3", w.Header().Get("X-Rule-Version"))
+	var list RuleSetsResponse
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Equal(t, "3", list.Active)
+	assert.Len(t, list.RuleSets, 3)
+
+	// An invalid file or a changed version leaves the rules as they are
+	os.WriteFile(cfg.RulesFile, []byte(`{"active": "4"}`), 0600)
+	w = serveJSON(router, "POST", "/rulesets/reload", nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "Unable to reload the rules: the active rule set 4 is not in the file.", w.Body.String())
+	os.WriteFile(cfg.RulesFile, []byte(`{"active": "3", "ruleSets": [{"version": "3", "oddDayPoints": 61}]}`), 0600)
+	w = serveJSON(router, "POST", "/rulesets/reload", nil)
+	assert.Equal(t, "Unable to reload the rules: rule set 3 can not be changed, add a new version instead.", w.Body.String())
+	assert.Equal(t, "3", ruleSets.active().Version)
+
+	w = serveJSON(router, "POST", "/rulesets/rollback", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-Rule-Version"))
+	serveJSON(router, "POST", "/receipts/process", validReceipt2)
+	var breakdown PointsBreakdown
+	json.Unmarshal(serveJSON(router, "GET", "/receipts/Receipt1/breakdown", nil).Body.Bytes(), &breakdown)
+	assert.Equal(t, "1", breakdown.RuleVersion)
+
+	teardown()
+}
+
+// TestReloadWithoutRulesFile
+func TestReloadWithoutRulesFile(t *testing.T) {
+	setup()
+	router := newTestRouter(t, defaultConfig())
+	w := serveJSON(router, "POST", "/rulesets/reload", nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "Unable to reload the rules: no rules file is configured.", w.Body.String())
+	teardown()
+}
+
+// TestWatchRuleSets
+func TestWatchRuleSets(t *testing.T) {
+	registry, err := loadRuleSets(writeRulesFile(t, "1"))
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var buf bytes.Buffer
+	go registry.watch(ctx, 5*time.Millisecond, newTestLogger(&buf))
+
+	os.WriteFile(registry.path, []byte(`{"active": "2", "ruleSets": [{"version": "2", "roundDollarPoints": 100, "pairPoints": 10}]}`), 0600)
+	assert.Eventually(t, func() bool {
+		return registry.active().Version == "2"
+	}, time.Second, 5*time.Millisecond)
+}
+
+// TestSwapIsAtomic
+func TestSwapIsAtomic(t *testing.T) {
+	registry, _ := loadRuleSets(writeRulesFile(t, "1"))
+	loaded, _ := loadRuleSets(writeRulesFile(t, "2"))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			rules := registry.active()
+			// Each version is seen whole, never the points of one with the name of another
+			if rules.Version == "1" && rules.PairPoints != 5 || rules.Version == "2" && rules.PairPoints != 10 {
+				t.Error("saw a mixed rule set")
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		registry.swap(loaded)
+		registry.rollback()
+	}
+	<-done
+}
+
+// writeOverridesFile writes a rules file with versions 2, 3 and 4 and overlapping overrides
+func writeOverridesFile(t *testing.T) string {
+	data := `{"ruleSets": [{"version": "2"}, {"version": "3"}, {"version": "4"}], "overrides": [
+		{"retailer": "Target", "version": "2"},
+		{"tenant": "acme", "version": "3"},
+		{"tenant": "acme", "retailer": "  TARGET ", "version": "4"}
+	]}`
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestResolveRuleOverrides
+func TestResolveRuleOverrides(t *testing.T) {
+	registry, err := loadRuleSets(writeOverridesFile(t))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		tenant, retailer, version, matchedBy string
+	}{
+		// The override for both wins over the tenant and the retailer overrides that also match
+		{"acme", "target", "4", matchedTenantAndRetailer},
+		{"acme", "Walgreens", "3", matchedTenant},
+		// A tenant override wins over a retailer override
+		{"globex", "Target", "2", matchedRetailer},
+		{"", "  target  ", "2", matchedRetailer},
+		{"", "Walgreens", "1", matchedActive},
+		{"globex", "Walgreens", "1", matchedActive},
+	}
+	for _, test := range tests {
+		rules, matchedBy := registry.resolve(test.tenant, test.retailer)
+		assert.Equal(t, test.version, rules.Version, test)
+		assert.Equal(t, test.matchedBy, matchedBy, test)
+	}
+
+	invalid := map[string]string{
+		"no tenant or retailer": `{"overrides": [{"version": "1"}]}`,
+		"unknown version":       `{"overrides": [{"retailer": "Target", "version": "2"}]}`,
+		"listed twice":          `{"overrides": [{"retailer": "Target", "version": "1"}, {"retailer": "target", "version": "1"}]}`,
+	}
+	for name, data := range invalid {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		os.WriteFile(path, []byte(data), 0600)
+		if _, err := loadRuleSets(path); err == nil {
+			t.Fatalf("loadRuleSets(%s) returned no error", name)
+		}
+	}
+}
+
+// TestResolveRuleSetEndpoint
+func TestResolveRuleSetEndpoint(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.RulesFile = writeOverridesFile(t)
+	keys := []APIKey{
+		{ClientID: "client-a", KeyHash: hashAPIKey("key-a"), Tenant: "acme"},
+		{ClientID: "client-b", KeyHash: hashAPIKey("key-b")},
+	}
+	data, _ := json.Marshal(keys)
+	cfg.Auth.APIKeysFile = filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(cfg.Auth.APIKeysFile, data, 0600)
+	router := newTestRouter(t, cfg)
+
+	var resolution RuleResolution
+	w := serveJSON(router, "POST", "/rulesets/resolve", validReceipt1, "X-API-Key", "key-a")
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &resolution)
+	assert.Equal(t, RuleResolution{RuleVersion: "4", Tenant: "acme", Retailer: "target", MatchedBy: matchedTenantAndRetailer}, resolution)
+
+	resolution = RuleResolution{}
+	json.Unmarshal(serveJSON(router, "POST", "/rulesets/resolve", validReceipt1, "X-API-Key", "key-b").Body.Bytes(), &resolution)
+	assert.Equal(t, RuleResolution{RuleVersion: "2", Retailer: "target", MatchedBy: matchedRetailer}, resolution)
+
+	// Receipts are scored and record the version that applied to them
+	serveJSON(router, "POST", "/receipts/process", validReceipt2, "X-API-Key", "key-a")
+	w = serveJSON(router, "GET", "/receipts/Receipt1/points", nil, "X-API-Key", "key-a")
+	expectedResponse, _ := json.Marshal(PointsGeneratedResponse{Points: 109, RuleVersion: "3"})
+	assert.Equal(t, string(expectedResponse), w.Body.String())
+
+	w = serveJSON(router, "POST", "/rulesets/resolve", Receipt{}, "X-API-Key", "key-a")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The receipt is invalid.", w.Body.String())
+
+	teardown()
+}
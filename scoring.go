@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RulePoints is the points one rule gave a receipt
+type RulePoints struct {
+	Rule   string `json:"rule"`
+	Points int64  `json:"points"`
+}
+
+// PointsBreakdown shows how the points of a receipt add up
+type PointsBreakdown struct {
+	// RuleVersion is the version of the rule set the receipt was scored with
+	RuleVersion string       `json:"ruleVersion"`
+	Rules       []RulePoints `json:"rules"`
+	// Base is the sum of the rules
+	Base int64 `json:"base"`
+	// Campaigns are the bonuses of the campaigns the receipt matched
+	Campaigns []CampaignBonus `json:"campaigns"`
+	// Tier is the adjustment for the customer's loyalty tier, if any
+	Tier  *TierAdjustment `json:"tier,omitempty"`
+	Total int64           `json:"total"`
+	// campaignTerms are the campaigns as they were when the bonuses were given, used to score the receipt again
+	campaignTerms map[string]Campaign
+}
+
+// baseBreakdown runs the rules that apply to the tenant and retailer of the receipt
+func baseBreakdown(receipt Receipt) *PointsBreakdown {
+	rules, _ := ruleSets.resolve(receipt.Tenant, receipt.canonicalRetailer())
+	return ruleBreakdown(receipt, rules)
+}
+
+// ruleBreakdown runs the rules of the rule set on the receipt
+func ruleBreakdown(receipt Receipt, rules RuleSet) *PointsBreakdown {
+	breakdown := &PointsBreakdown{RuleVersion: rules.Version, Rules: rules.rulePoints(receipt), Campaigns: []CampaignBonus{}}
+	for _, rule := range breakdown.Rules {
+		breakdown.Base += rule.Points
+	}
+	breakdown.Total = breakdown.Base
+	return breakdown
+}
+
+// scoreReceipt runs the rules on the receipt, then adds the campaign bonuses and applies the customer's tier
+func scoreReceipt(receipt Receipt) *PointsBreakdown {
+	breakdown := baseBreakdown(receipt)
+	breakdown.Campaigns, breakdown.campaignTerms = campaigns.bonusesWithTerms(receipt, breakdown.Base)
+	for _, bonus := range breakdown.Campaigns {
+		breakdown.Total += bonus.Points
+	}
+	if receipt.CustomerID != "" {
+		breakdown.Tier = tiers.adjustment(receipt.CustomerID, breakdown.Base)
+		if breakdown.Tier != nil {
+			breakdown.Total += breakdown.Tier.Points
+		}
+	}
+	return breakdown
+}
+
+// rescoreReceipt runs the rules of the rule set on a stored receipt. The campaigns and tier it was awarded
+// when it was accepted are applied again to the new base points, so only the rules make a difference
+func rescoreReceipt(receipt Receipt, rules RuleSet) *PointsBreakdown {
+	breakdown := ruleBreakdown(receipt, rules)
+	if receipt.Breakdown == nil {
+		return breakdown
+	}
+	breakdown.campaignTerms = receipt.Breakdown.campaignTerms
+	for _, bonus := range receipt.Breakdown.Campaigns {
+		if terms, ok := receipt.Breakdown.campaignTerms[bonus.CampaignID]; ok {
+			bonus.Points = terms.bonus(breakdown.Base)
+		}
+		breakdown.Campaigns = append(breakdown.Campaigns, bonus)
+		breakdown.Total += bonus.Points
+	}
+	if tier := receipt.Breakdown.Tier; tier != nil {
+		breakdown.Tier = newTierAdjustment(tier.Tier, tier.Multiplier, tier.Bonus, breakdown.Base)
+		breakdown.Total += breakdown.Tier.Points
+	}
+	return breakdown
+}
+
+// receiptPoints returns the points the receipt was awarded when it was accepted
+func receiptPoints(receipt Receipt) int64 {
+	if receipt.Breakdown != nil {
+		return receipt.Breakdown.Total
+	}
+	return calcuatePoints(receipt)
+}
+
+// getBreakdown returns how the points of a receipt add up
+func getBreakdown(c *gin.Context) {
+	var receiptId = c.Param("id")
+	c.Set(receiptIDKey, receiptId)
+	receipt, ok := findReceipt(receiptId, requestOwner(c))
+	if !ok {
+		c.String(http.StatusNotFound, "No receipt found for that ID.")
+		return
+	}
+
+	breakdown := receipt.Breakdown
+	if breakdown == nil {
+		breakdown = baseBreakdown(receipt)
+	}
+	c.JSON(http.StatusOK, breakdown)
+}
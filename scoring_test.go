@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestScoreReceiptIsNotStored
+func TestScoreReceiptIsNotStored(t *testing.T) {
+	setup()
+	router := newTestRouter(t, defaultConfig())
+
+	w := serveJSON(router, "POST", "/receipts/score", validReceipt2)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response ReceiptScoreResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, int64(109), response.Points)
+	assert.Equal(t, int64(109), response.Breakdown.Base)
+	assert.Equal(t, defaultRuleVersion, response.Breakdown.RuleVersion)
+	assert.Empty(t, receiptsMap)
+	assert.Equal(t, 0, receiptNum)
+
+	// The same binding and validation as storing a receipt
+	w = serveJSON(router, "POST", "/receipts/score", receiptInvalidTotal)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The receipt is invalid.", w.Body.String())
+
+	// Scoring for a customer applies the tier but does not credit the customer
+	serveJSON(router, "POST", "/customers", CreateCustomerRequest{Name: "Ada"})
+	receipt := validReceipt2
+	receipt.CustomerID = "Customer1"
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/receipts/score", receipt).Code)
+	assert.Equal(t, int64(0), ledger.balance("Customer1"))
+	receipt.CustomerID = "Customer9"
+	assert.Equal(t, http.StatusBadRequest, serveJSON(router, "POST", "/receipts/score", receipt).Code)
+
+	teardown()
+}
+
+// TestScoreReceiptHasItsOwnLimits
+func TestScoreReceiptHasItsOwnLimits(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.RateLimit = RateLimitConfig{
+		DefaultTier: "free",
+		Tiers: map[string]RateTier{
+			"free": {
+				Routes: map[string]RateLimit{
+					"POST /receipts/score": {RequestsPerSecond: 0.001, Burst: 1},
+				},
+				DailyReceiptQuota: 1,
+			},
+		},
+	}
+	router := newTestRouter(t, cfg)
+
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/receipts/score", validReceipt1).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveJSON(router, "POST", "/receipts/score", validReceipt1).Code)
+	// Neither the score limit nor the daily quota of stored receipts affects the other path
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/receipts/process", validReceipt1).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveJSON(router, "POST", "/receipts/process", validReceipt1).Code)
+
+	teardown()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The lower bound of each bucket of the points distribution, the last bucket has no upper bound
+var distributionBuckets = []int64{0, 25, 50, 100, 200, 500}
+
+// SimulateRequest scores receipts with a candidate rule set next to the active one.
+// The receipts are either given inline or picked from the caller's stored receipts with the filter
+type SimulateRequest struct {
+	// Rules is the candidate rule set, rules that are left out keep the points of the active rule set
+	Rules    *json.RawMessage `json:"rules" binding:"required"`
+	Receipts []Receipt        `json:"receipts" binding:"dive"`
+	Filter   *RescoreFilter   `json:"filter"`
+}
+
+// DistributionBucket is the number of receipts with points in a range
+type DistributionBucket struct {
+	Range    string `json:"range"`
+	Receipts int    `json:"receipts"`
+}
+
+// PointsStatistics summarises the points of a set of receipts
+type PointsStatistics struct {
+	Total        int64                `json:"total"`
+	Mean         float64              `json:"mean"`
+	Min          int64                `json:"min"`
+	Max          int64                `json:"max"`
+	Distribution []DistributionBucket `json:"distribution"`
+}
+
+// RuleDelta compares the points one rule gives under the active and the candidate rule set
+type RuleDelta struct {
+	Rule       string `json:"rule"`
+	Current    int64  `json:"current"`
+	Candidate  int64  `json:"candidate"`
+	Difference int64  `json:"difference"`
+}
+
+type SimulateResponse struct {
+	Receipts int `json:"receipts"`
+	// Changed is the number of receipts whose points differ between the rule sets
+	Changed   int              `json:"changed"`
+	Current   PointsStatistics `json:"current"`
+	Candidate PointsStatistics `json:"candidate"`
+	Rules     []RuleDelta      `json:"rules"`
+}
+
+// newPointsStatistics works out the total, mean, range and distribution of the points
+func newPointsStatistics(points []int64) PointsStatistics {
+	stats := PointsStatistics{Distribution: make([]DistributionBucket, len(distributionBuckets))}
+	for i, lower := range distributionBuckets {
+		stats.Distribution[i].Range = strconv.FormatInt(lower, 10) + "+"
+		if i+1 < len(distributionBuckets) {
+			stats.Distribution[i].Range = fmt.Sprintf("%d-%d", lower, distributionBuckets[i+1]-1)
+		}
+	}
+	for i, value := range points {
+		stats.Total += value
+		if i == 0 || value < stats.Min {
+			stats.Min = value
+		}
+		if i == 0 || value > stats.Max {
+			stats.Max = value
+		}
+		bucket := 0
+		for j, lower := range distributionBuckets {
+			if value >= lower {
+				bucket = j
+			}
+		}
+		stats.Distribution[bucket].Receipts += 1
+	}
+	if len(points) > 0 {
+		stats.Mean = float64(stats.Total) / float64(len(points))
+	}
+	return stats
+}
+
+// simulateRules scores the receipts with the rules they resolve to now and with the candidate, and compares the results
+func simulateRules(receipts []Receipt, candidate RuleSet) SimulateResponse {
+	response := SimulateResponse{Receipts: len(receipts), Rules: []RuleDelta{}}
+	currentPoints := make([]int64, 0, len(receipts))
+	candidatePoints := make([]int64, 0, len(receipts))
+	// The index of each rule in response.Rules
+	deltas := make(map[string]int)
+	for _, receipt := range receipts {
+		current, _ := ruleSets.resolve(receipt.Tenant, receipt.canonicalRetailer())
+		currentBreakdown := ruleBreakdown(receipt, current)
+		candidateBreakdown := ruleBreakdown(receipt, candidate)
+		currentPoints = append(currentPoints, currentBreakdown.Base)
+		candidatePoints = append(candidatePoints, candidateBreakdown.Base)
+		if currentBreakdown.Base != candidateBreakdown.Base {
+			response.Changed += 1
+		}
+		// The rule sets can have different custom rules
+		for _, rule := range currentBreakdown.Rules {
+			response.ruleDelta(deltas, rule.Rule).Current += rule.Points
+		}
+		for _, rule := range candidateBreakdown.Rules {
+			response.ruleDelta(deltas, rule.Rule).Candidate += rule.Points
+		}
+	}
+	for i := range response.Rules {
+		response.Rules[i].Difference = response.Rules[i].Candidate - response.Rules[i].Current
+	}
+	response.Current = newPointsStatistics(currentPoints)
+	response.Candidate = newPointsStatistics(candidatePoints)
+	return response
+}
+
+// ruleDelta returns the delta for the rule, adding it when it is not there yet
+func (response *SimulateResponse) ruleDelta(deltas map[string]int, rule string) *RuleDelta {
+	index, ok := deltas[rule]
+	if !ok {
+		index = len(response.Rules)
+		deltas[rule] = index
+		response.Rules = append(response.Rules, RuleDelta{Rule: rule})
+	}
+	return &response.Rules[index]
+}
+
+// simulatedReceipts returns the inline receipts after checking them, or the caller's stored receipts that pass the filter
+func simulatedReceipts(c *gin.Context, request SimulateRequest) ([]Receipt, error) {
+	owner, limits := requestOwner(c), requestLimits(c)
+	if len(request.Receipts) > 0 {
+		if request.Filter != nil {
+			return nil, errors.New("use either receipts or filter")
+		}
+		for i := range request.Receipts {
+			if err := checkReceiptLimits(&request.Receipts[i], limits); err != nil {
+				return nil, fmt.Errorf("receipts[%d]: %w", i, err)
+			}
+			// The same checks as a processed receipt, only the reasons meant for the client are given
+			if err := validateReceipt(c, &request.Receipts[i]); err != nil {
+				var reqErr *requestError
+				if errors.As(err, &reqErr) {
+					return nil, fmt.Errorf("receipts[%d]: %w", i, err)
+				}
+				return nil, fmt.Errorf("receipts[%d] is invalid", i)
+			}
+		}
+		return request.Receipts, nil
+	}
+
+	filter := RescoreFilter{}
+	if request.Filter != nil {
+		filter = *request.Filter
+	}
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+	receiptsMutex.RLock()
+	defer receiptsMutex.RUnlock()
+	receipts := []Receipt{}
+	for _, receipt := range receiptsMap {
+		if receipt.Owner == owner && filter.matches(receipt) {
+			receipts = append(receipts, receipt)
+		}
+	}
+	return receipts, nil
+}
+
+// simulate compares the points of the receipts under the active and a candidate rule set, nothing is stored
+func simulate(c *gin.Context) {
+	if limits := requestLimits(c); limits.MaxBodyBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limits.MaxBodyBytes)
+	}
+	var request SimulateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusBadRequest, "The simulation is invalid.")
+		return
+	}
+
+	// Rules that are left out keep the points of the active rule set
+	candidate := ruleSets.active()
+	candidate.Version = "candidate"
+	if err := json.Unmarshal(*request.Rules, &candidate); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusBadRequest, "The simulation is invalid: the rules are invalid.")
+		return
+	}
+	if err := candidate.validate(); err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusBadRequest, "The simulation is invalid: "+err.Error()+".")
+		return
+	}
+
+	receipts, err := simulatedReceipts(c, request)
+	if err != nil {
+		c.Set(validationErrorKey, err.Error())
+		c.String(http.StatusBadRequest, "The simulation is invalid: "+err.Error()+".")
+		return
+	}
+	c.JSON(http.StatusOK, simulateRules(receipts, candidate))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The candidate rules of the simulation tests, the same as version 2 in writeRulesFile
+var candidateRules = rawRules(`{"roundDollarPoints": 100, "pairPoints": 10}`)
+
+// rawRules returns the rules for a SimulateRequest
+func rawRules(rules string) *json.RawMessage {
+	raw := json.RawMessage(rules)
+	return &raw
+}
+
+// TestSimulateInlineReceipts
+func TestSimulateInlineReceipts(t *testing.T) {
+	setup()
+	router := newTestRouter(t, defaultConfig())
+
+	w := serveJSON(router, "POST", "/simulate", SimulateRequest{Rules: candidateRules, Receipts: []Receipt{validReceipt1, validReceipt2}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response SimulateResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, 2, response.Receipts)
+	assert.Equal(t, 2, response.Changed)
+
+	assert.Equal(t, int64(137), response.Current.Total)
+	assert.Equal(t, 68.5, response.Current.Mean)
+	assert.Equal(t, int64(28), response.Current.Min)
+	assert.Equal(t, int64(109), response.Current.Max)
+	assert.Equal(t, DistributionBucket{Range: "25-49", Receipts: 1}, response.Current.Distribution[1])
+	assert.Equal(t, DistributionBucket{Range: "100-199", Receipts: 1}, response.Current.Distribution[3])
+	assert.Equal(t, DistributionBucket{Range: "500+", Receipts: 0}, response.Current.Distribution[5])
+	assert.Equal(t, int64(207), response.Candidate.Total)
+	assert.Equal(t, int64(169), response.Candidate.Max)
+
+	assert.Contains(t, response.Rules, RuleDelta{Rule: "roundDollar", Current: 50, Candidate: 100, Difference: 50})
+	assert.Contains(t, response.Rules, RuleDelta{Rule: "itemPairs", Current: 20, Candidate: 40, Difference: 20})
+	assert.Contains(t, response.Rules, RuleDelta{Rule: "oddPurchaseDay", Current: 6, Candidate: 6, Difference: 0})
+
+	// Nothing is stored
+	assert.Empty(t, receiptsMap)
+
+	teardown()
+}
+
+// TestSimulateStoredReceipts
+func TestSimulateStoredReceipts(t *testing.T) {
+	setup()
+	router := newTestRouter(t, defaultConfig())
+	serveJSON(router, "POST", "/receipts/process", validReceipt1)
+	serveJSON(router, "POST", "/receipts/process", validReceipt2)
+
+	var response SimulateResponse
+	w := serveJSON(router, "POST", "/simulate", SimulateRequest{Rules: candidateRules, Filter: &RescoreFilter{Retailer: "target"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, 1, response.Receipts)
+	assert.Equal(t, int64(38), response.Candidate.Total)
+
+	json.Unmarshal(serveJSON(router, "POST", "/simulate", SimulateRequest{Rules: candidateRules}).Body.Bytes(), &response)
+	assert.Equal(t, 2, response.Receipts)
+	assert.Len(t, receiptsMap, 2)
+
+	teardown()
+}
+
+// TestSimulateInvalid
+func TestSimulateInvalid(t *testing.T) {
+	setup()
+	router := newTestRouter(t, defaultConfig())
+
+	invalid := validReceipt1
+	invalid.Retailer = "Target!"
+	tests := map[string]SimulateRequest{
+		"The simulation is invalid.":                                                             {Receipts: []Receipt{validReceipt1}},
+		"The simulation is invalid: the rules are invalid.":                                      {Rules: rawRules(`{"pairPoints": "ten"}`)},
+		"The simulation is invalid: rule set candidate: afternoonEnd must use the layout 15:04.": {Rules: rawRules(`{"afternoonEnd": "4pm"}`)},
+		"The simulation is invalid: receipts[1] is invalid.":                                     {Rules: candidateRules, Receipts: []Receipt{validReceipt1, invalid}},
+		"The simulation is invalid: use either receipts or filter.":                              {Rules: candidateRules, Receipts: []Receipt{validReceipt1}, Filter: &RescoreFilter{}},
+	}
+	for expected, request := range tests {
+		w := serveJSON(router, "POST", "/simulate", request)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expected, w.Body.String())
+	}
+
+	teardown()
+}
+
+// TestSimulateReceiptChecks
+func TestSimulateReceiptChecks(t *testing.T) {
+	setup()
+	clock = fixedClock(time.Date(2022, time.March, 21, 12, 0, 0, 0, time.UTC))
+	cfg := defaultConfig()
+	cfg.PurchaseWindow = PurchaseWindowConfig{MaxFutureDays: days(0)}
+	router := newTestRouter(t, cfg)
+
+	// Inline receipts get the purchase window and customer checks of processed receipts
+	future := validReceipt2
+	future.PurchaseDate = "2022-03-22"
+	unknownCustomer := validReceipt2
+	unknownCustomer.CustomerID = "Customer99"
+	tests := map[string]Receipt{
+		"The simulation is invalid: receipts[1]: the purchase is in the future.": future,
+		"The simulation is invalid: receipts[1]: unknown customer Customer99.":   unknownCustomer,
+	}
+	for expected, receipt := range tests {
+		w := serveJSON(router, "POST", "/simulate", SimulateRequest{Rules: candidateRules, Receipts: []Receipt{validReceipt2, receipt}})
+		assert.Equal(t, http.StatusBadRequest, w.Code, expected)
+		assert.Equal(t, expected, w.Body.String())
+	}
+
+	teardown()
+}
+
+// TestSimulateResolvesOverrides
+func TestSimulateResolvesOverrides(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.RulesFile = writeRetailerOverrideFile(t)
+	router := newTestRouter(t, cfg)
+
+	// The current points are those of the retailer override, the candidate is the active rule set unchanged
+	var response SimulateResponse
+	w := serveJSON(router, "POST", "/simulate", SimulateRequest{Rules: rawRules(`{}`), Receipts: []Receipt{validReceipt1, validReceipt2}})
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, int64(28+559), response.Current.Total)
+	assert.Equal(t, int64(28+109), response.Candidate.Total)
+	assert.Equal(t, 1, response.Changed)
+	assert.Contains(t, response.Rules, RuleDelta{Rule: "roundDollar", Current: 500, Candidate: 50, Difference: -450})
+
+	teardown()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every route is also served under this prefix when TenantsConfig.PathPrefix is set
+const (
+	tenantParam      = "tenant"
+	tenantPathPrefix = "/t/:" + tenantParam
+)
+
+// TenantsConfig controls how the tenant of a request is found, the service has a single tenant when it is empty
+type TenantsConfig struct {
+	// Hosts maps a Host header, without the port, to a tenant
+	Hosts map[string]string `json:"hosts"`
+	// PathPrefix serves every route under /t/{tenant} as well
+	PathPrefix bool `json:"pathPrefix"`
+	// Tenants holds the settings of each tenant. When it is not empty requests for other tenants are rejected
+	Tenants map[string]TenantConfig `json:"tenants"`
+}
+
+// TenantConfig replaces the service settings for one tenant, settings that are left out keep the service ones.
+// The rules of a tenant are picked with the overrides of the rules file
+type TenantConfig struct {
+	RateLimit      *RateLimitConfig      `json:"rateLimit"`
+	Limits         *LimitsConfig         `json:"limits"`
+	PurchaseWindow *PurchaseWindowConfig `json:"purchaseWindow"`
+}
+
+// validTenant reports whether the name can be used as a tenant, the owner keys use "/" as a separator
+func validTenant(tenant string) bool {
+	return tenant != "" && !strings.Contains(tenant, "/")
+}
+
+// validate checks the tenant names and that every host maps to a configured tenant
+func (cfg TenantsConfig) validate() error {
+	for tenant, tenantCfg := range cfg.Tenants {
+		if !validTenant(tenant) {
+			return fmt.Errorf("tenant %q needs a name without a /", tenant)
+		}
+		if window := tenantCfg.PurchaseWindow; window != nil {
+			if err := window.validate(); err != nil {
+				return fmt.Errorf("tenant %s: %w", tenant, err)
+			}
+		}
+	}
+	for host, tenant := range cfg.Hosts {
+		if !validTenant(tenant) {
+			return fmt.Errorf("host %s needs a tenant name without a /", host)
+		}
+		if _, ok := cfg.Tenants[tenant]; len(cfg.Tenants) > 0 && !ok {
+			return fmt.Errorf("host %s maps to the unknown tenant %s", host, tenant)
+		}
+	}
+	return nil
+}
+
+// resolveTenant records the tenant of the request. The tenant of the credentials comes first, a request
+// for another tenant through the path prefix or the host is rejected. The path prefix wins over the host.
+// Credentials without a tenant can only pick one with the admin scope
+func resolveTenant(cfg TenantsConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requested := c.Param(tenantParam)
+		if requested == "" {
+			host, _, err := net.SplitHostPort(c.Request.Host)
+			if err != nil {
+				host = c.Request.Host
+			}
+			requested = cfg.Hosts[strings.ToLower(host)]
+		}
+
+		tenant := requestTenant(c)
+		if tenant != "" && requested != "" && tenant != requested {
+			c.String(http.StatusForbidden, "The credentials are not valid for this tenant.")
+			c.Abort()
+			return
+		}
+		if scopes, ok := c.Get(scopesKey); ok && tenant == "" && requested != "" && !slices.Contains(scopes.([]string), scopeAdmin) {
+			c.String(http.StatusForbidden, "The credentials are not valid for this tenant.")
+			c.Abort()
+			return
+		}
+		if tenant == "" {
+			tenant = requested
+		}
+		if tenant != "" {
+			if _, ok := cfg.Tenants[tenant]; !validTenant(tenant) || len(cfg.Tenants) > 0 && !ok {
+				c.String(http.StatusNotFound, "Unknown tenant.")
+				c.Abort()
+				return
+			}
+		}
+		c.Set(tenantKey, tenant)
+		c.Next()
+	}
+}
+
+// ownerKey identifies who owns the stored receipts, customers and redemptions. Clients of different
+// tenants, or the unauthenticated callers of different tenants, never own the same data
+func ownerKey(tenant string, clientID string) string {
+	if tenant == "" {
+		return clientID
+	}
+	return tenant + "/" + clientID
+}
+
+// requestOwner returns the owner key of the request
+func requestOwner(c *gin.Context) string {
+	return ownerKey(requestTenant(c), requestClient(c))
+}
+
+// tenantConfig returns the settings of the tenant of the request
+func tenantConfig(c *gin.Context) TenantConfig {
+	return appConfig.Tenants.Tenants[requestTenant(c)]
+}
+
+// requestLimits returns the receipt limits of the tenant of the request
+func requestLimits(c *gin.Context) LimitsConfig {
+	if limits := tenantConfig(c).Limits; limits != nil {
+		return *limits
+	}
+	return appConfig.Limits
+}
+
+// requestRateLimit returns the rate limits of the tenant of the request, cfg when it has none of its own
+func requestRateLimit(cfg RateLimitConfig, c *gin.Context) RateLimitConfig {
+	if rateLimit := tenantConfig(c).RateLimit; rateLimit != nil {
+		return *rateLimit
+	}
+	return cfg
+}
+
+// requestPurchaseWindow returns the purchase window of the tenant of the request
+func requestPurchaseWindow(c *gin.Context) PurchaseWindowConfig {
+	if window := tenantConfig(c).PurchaseWindow; window != nil {
+		return *window
+	}
+	return appConfig.PurchaseWindow
+}
+
+// routePath returns the route of the request without the tenant path prefix
+func routePath(c *gin.Context) string {
+	return strings.TrimPrefix(c.FullPath(), tenantPathPrefix)
+}
+
+// tenantLabels adds the tenant of the request to the metric labels when there is one
+func tenantLabels(c *gin.Context, labels ...string) []string {
+	if tenant := requestTenant(c); tenant != "" {
+		return append(labels, "tenant", tenant)
+	}
+	return labels
+}
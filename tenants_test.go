@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTenantConfig returns a config with the tenants acme and globex, found by host or path prefix
+func newTenantConfig() Config {
+	cfg := defaultConfig()
+	cfg.Tenants = TenantsConfig{
+		Hosts:      map[string]string{"acme.example.com": "acme", "globex.example.com": "globex"},
+		PathPrefix: true,
+		Tenants:    map[string]TenantConfig{"acme": {}, "globex": {}},
+	}
+	return cfg
+}
+
+// TestTenantIsolation
+func TestTenantIsolation(t *testing.T) {
+	setup()
+	router := newTestRouter(t, newTenantConfig())
+
+	w := serveJSON(router, "POST", "http://acme.example.com:8080/receipts/process", validReceipt1)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "acme", receiptsMap["Receipt1"].Tenant)
+
+	// The host and the path prefix find the same tenant
+	assert.Equal(t, http.StatusOK, serveJSON(router, "GET", "http://acme.example.com/receipts/Receipt1/points", nil).Code)
+	assert.Equal(t, http.StatusOK, serveJSON(router, "GET", "/t/acme/receipts/Receipt1/points", nil).Code)
+
+	// Other tenants and requests without a tenant can not read it
+	for _, path := range []string{"http://globex.example.com/receipts/Receipt1/points", "/t/globex/receipts/Receipt1/points", "/receipts/Receipt1/points"} {
+		w = serveJSON(router, "GET", path, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+		assert.Equal(t, "No receipt found for that ID.", w.Body.String(), path)
+	}
+
+	// Customers are isolated too
+	var created CustomerCreatedResponse
+	json.Unmarshal(serveJSON(router, "POST", "/t/acme/customers", CreateCustomerRequest{Name: "Ann"}).Body.Bytes(), &created)
+	assert.Equal(t, "acme", customersMap[created.ID].Tenant)
+	assert.Equal(t, http.StatusOK, serveJSON(router, "GET", "/t/acme/customers/"+created.ID+"/balance", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "GET", "/t/globex/customers/"+created.ID+"/balance", nil).Code)
+	assert.Equal(t, http.StatusOK, serveJSON(router, "GET", "/t/acme/customers/"+created.ID+"/ledger", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "GET", "/t/globex/customers/"+created.ID+"/ledger", nil).Code)
+	// Customers created without a tenant are not visible to a tenant either
+	var untenanted CustomerCreatedResponse
+	json.Unmarshal(serveJSON(router, "POST", "/customers", CreateCustomerRequest{Name: "Bob"}).Body.Bytes(), &untenanted)
+	assert.Equal(t, http.StatusOK, serveJSON(router, "GET", "/customers/"+untenanted.ID+"/ledger", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "GET", "/t/globex/customers/"+untenanted.ID+"/ledger", nil).Code)
+	receipt := validReceipt2
+	receipt.CustomerID = created.ID
+	assert.Equal(t, http.StatusBadRequest, serveJSON(router, "POST", "/t/globex/receipts/process", receipt).Code)
+
+	w = serveJSON(router, "GET", "/t/initech/receipts/Receipt1/points", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "Unknown tenant.", w.Body.String())
+
+	teardown()
+}
+
+// TestTenantFromCredentials
+func TestTenantFromCredentials(t *testing.T) {
+	setup()
+	cfg := newTenantConfig()
+	data, _ := json.Marshal([]APIKey{
+		{ClientID: "client-a", KeyHash: hashAPIKey("key-a"), Tenant: "acme"},
+		{ClientID: "client-b", KeyHash: hashAPIKey("key-b"), Tenant: "globex"},
+		{ClientID: "client-c", KeyHash: hashAPIKey("key-c")},
+		{ClientID: "client-d", KeyHash: hashAPIKey("key-d"), Scopes: []string{scopeAdmin}},
+	})
+	cfg.Auth.APIKeysFile = filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(cfg.Auth.APIKeysFile, data, 0600)
+	router := newTestRouter(t, cfg)
+
+	// The tenant of the key is used without a host or path prefix
+	serveJSON(router, "POST", "/receipts/process", validReceipt1, "X-API-Key", "key-a")
+	assert.Equal(t, "acme", receiptsMap["Receipt1"].Tenant)
+	assert.Equal(t, http.StatusOK, serveJSON(router, "GET", "/t/acme/receipts/Receipt1/points", nil, "X-API-Key", "key-a").Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "GET", "/receipts/Receipt1/points", nil, "X-API-Key", "key-b").Code)
+
+	// A key can not be used for another tenant
+	for _, path := range []string{"/t/globex/receipts/Receipt1/points", "http://globex.example.com/receipts/Receipt1/points"} {
+		w := serveJSON(router, "GET", path, nil, "X-API-Key", "key-a")
+		assert.Equal(t, http.StatusForbidden, w.Code, path)
+		assert.Equal(t, "The credentials are not valid for this tenant.", w.Body.String(), path)
+	}
+
+	// A key without a tenant can not pick one, unless it is an admin key
+	for _, path := range []string{"/t/acme/rulesets", "/t/acme/retailers", "http://acme.example.com/receipts/Receipt1/points"} {
+		w := serveJSON(router, "GET", path, nil, "X-API-Key", "key-c")
+		assert.Equal(t, http.StatusForbidden, w.Code, path)
+		assert.Equal(t, "The credentials are not valid for this tenant.", w.Body.String(), path)
+	}
+	assert.Equal(t, http.StatusOK, serveJSON(router, "GET", "/rulesets", nil, "X-API-Key", "key-c").Code)
+	assert.Equal(t, http.StatusOK, serveJSON(router, "GET", "/t/acme/retailers", nil, "X-API-Key", "key-d").Code)
+
+	teardown()
+}
+
+// TestTenantLimits
+func TestTenantLimits(t *testing.T) {
+	setup()
+	cfg := newTenantConfig()
+	limits := cfg.Limits
+	limits.MaxItems = 4
+	cfg.Tenants.Tenants["globex"] = TenantConfig{
+		Limits: &limits,
+		RateLimit: &RateLimitConfig{
+			DefaultTier: "basic",
+			Tiers:       map[string]RateTier{"basic": {Routes: map[string]RateLimit{"POST /receipts/score": {RequestsPerSecond: 0.001, Burst: 1}}}},
+		},
+	}
+	router := newTestRouter(t, cfg)
+
+	// validReceipt1 has five items
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/t/acme/receipts/process", validReceipt1).Code)
+	assert.Equal(t, http.StatusBadRequest, serveJSON(router, "POST", "/t/globex/receipts/process", validReceipt1).Code)
+
+	// The rate limit applies to the route with or without the path prefix
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/t/globex/receipts/score", validReceipt2).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveJSON(router, "POST", "http://globex.example.com/receipts/score", validReceipt2).Code)
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/t/acme/receipts/score", validReceipt2).Code)
+
+	teardown()
+}
+
+// TestTenantMetrics
+func TestTenantMetrics(t *testing.T) {
+	setup()
+	router := newTestRouter(t, newTenantConfig())
+	serveJSON(router, "POST", "/t/acme/receipts/process", validReceipt1)
+	serveJSON(router, "POST", "/t/globex/receipts/process", validReceipt2)
+	serveJSON(router, "POST", "/receipts/process", validReceipt2)
+
+	assert.Equal(t, int64(1), metrics.get(`receipts_processed_total{result="accepted",tenant="acme"}`))
+	assert.Equal(t, int64(28), metrics.get(`points_awarded_total{tenant="acme"}`))
+	assert.Equal(t, int64(1), metrics.get(`receipts_processed_total{result="accepted"}`))
+
+	// A tenant only sees its own counters
+	body := serveJSON(router, "GET", "/t/globex/metrics", nil).Body.String()
+	assert.Equal(t, "points_awarded_total{tenant=\"globex\"} 109\nreceipts_processed_total{result=\"accepted\",tenant=\"globex\"} 1\n", body)
+	assert.True(t, strings.Contains(serveJSON(router, "GET", "/metrics", nil).Body.String(), `tenant="acme"`))
+
+	teardown()
+}
+
+// TestTenantRuleSets
+func TestTenantRuleSets(t *testing.T) {
+	setup()
+	data := `{"ruleSets": [{"version": "2", "tenant": "acme", "pairPoints": 10}], "overrides": [{"tenant": "acme", "version": "2"}]}`
+	cfg := newTenantConfig()
+	cfg.RulesFile = filepath.Join(t.TempDir(), "rules.json")
+	os.WriteFile(cfg.RulesFile, []byte(data), 0600)
+	router := newTestRouter(t, cfg)
+
+	var list RuleSetsResponse
+	json.Unmarshal(serveJSON(router, "GET", "/t/acme/rulesets", nil).Body.Bytes(), &list)
+	assert.Len(t, list.RuleSets, 2)
+	assert.Len(t, list.Overrides, 1)
+	json.Unmarshal(serveJSON(router, "GET", "/t/globex/rulesets", nil).Body.Bytes(), &list)
+	assert.Len(t, list.RuleSets, 1)
+	assert.Len(t, list.Overrides, 0)
+	// Requests without a tenant only see the shared rule sets
+	json.Unmarshal(serveJSON(router, "GET", "/rulesets", nil).Body.Bytes(), &list)
+	assert.Len(t, list.RuleSets, 1)
+	assert.Len(t, list.Overrides, 0)
+
+	serveJSON(router, "POST", "/t/globex/receipts/process", validReceipt2)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, "GET", "/t/globex/receipts/Receipt1/rescore?version=2", nil).Code)
+	w := serveJSON(router, "POST", "/t/globex/jobs/rescore", RescoreJobRequest{Version: "2"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	invalid := map[string]string{
+		"active tenant set":  `{"active": "2", "ruleSets": [{"version": "2", "tenant": "acme"}]}`,
+		"other tenant":       `{"ruleSets": [{"version": "2", "tenant": "acme"}], "overrides": [{"tenant": "globex", "version": "2"}]}`,
+		"retailer-only used": `{"ruleSets": [{"version": "2", "tenant": "acme"}], "overrides": [{"retailer": "Target", "version": "2"}]}`,
+	}
+	for name, data := range invalid {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		os.WriteFile(path, []byte(data), 0600)
+		if _, err := loadRuleSets(path); err == nil {
+			t.Fatalf("loadRuleSets(%s) returned no error", name)
+		}
+	}
+
+	teardown()
+}
+
+// TestTenantCampaigns
+func TestTenantCampaigns(t *testing.T) {
+	setup()
+	router := newTestRouter(t, newTenantConfig())
+	campaign := Campaign{Name: "Double", Start: "2022-01-01T00:00", End: "2022-12-31T23:59", Bonus: 100, Tenant: "globex"}
+	var created Campaign
+	json.Unmarshal(serveJSON(router, "POST", "/t/acme/campaigns", campaign).Body.Bytes(), &created)
+	assert.Equal(t, "acme", created.Tenant)
+
+	// The campaign only adds points to the receipts of its tenant
+	var score ReceiptScoreResponse
+	json.Unmarshal(serveJSON(router, "POST", "/t/acme/receipts/score", validReceipt2).Body.Bytes(), &score)
+	assert.Equal(t, int64(209), score.Points)
+	for _, path := range []string{"/t/globex/receipts/score", "/receipts/score"} {
+		json.Unmarshal(serveJSON(router, "POST", path, validReceipt2).Body.Bytes(), &score)
+		assert.Equal(t, int64(109), score.Points, path)
+	}
+
+	// Other tenants can not see or change it
+	var list []Campaign
+	json.Unmarshal(serveJSON(router, "GET", "/t/globex/campaigns", nil).Body.Bytes(), &list)
+	assert.Len(t, list, 0)
+	json.Unmarshal(serveJSON(router, "GET", "/t/acme/campaigns", nil).Body.Bytes(), &list)
+	assert.Len(t, list, 1)
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		for _, prefix := range []string{"/t/globex", ""} {
+			w := serveJSON(router, method, prefix+"/campaigns/"+created.ID, campaign)
+			assert.Equal(t, http.StatusNotFound, w.Code, method+" "+prefix)
+		}
+	}
+	assert.Equal(t, http.StatusNoContent, serveJSON(router, "DELETE", "/t/acme/campaigns/"+created.ID, nil).Code)
+
+	teardown()
+}
+
+// TestTenantsConfigValidate
+func TestTenantsConfigValidate(t *testing.T) {
+	assert.NoError(t, newTenantConfig().Tenants.validate())
+	assert.EqualError(t, TenantsConfig{Tenants: map[string]TenantConfig{"a/b": {}}}.validate(), `tenant "a/b" needs a name without a /`)
+	cfg := newTenantConfig().Tenants
+	cfg.Hosts["initech.example.com"] = "initech"
+	assert.EqualError(t, cfg.validate(), "host initech.example.com maps to the unknown tenant initech")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Character policies for the retailer and the item descriptions
+const (
+	// textPolicyASCII allows ASCII letters, digits, underscores, spaces, - and & as api.yml describes
+	textPolicyASCII = "ascii"
+	// textPolicyUnicode allows letters and digits of any script, spaces, -, & and the configured punctuation
+	textPolicyUnicode = "unicode"
+)
+
+// TextConfig sets which characters the retailer and item descriptions can have
+type TextConfig struct {
+	Policy string `json:"policy"`
+	// Punctuation is the extra characters allowed by the unicode policy
+	Punctuation string `json:"punctuation"`
+}
+
+// The pattern of the ascii policy
+var asciiTextPattern = regexp.MustCompile(`^[\w\s\-&]+$`)
+
+// validate checks the policy is known
+func (cfg TextConfig) validate() error {
+	if cfg.Policy != textPolicyASCII && cfg.Policy != textPolicyUnicode {
+		return errors.New(`the text policy must be "ascii" or "unicode"`)
+	}
+	return nil
+}
+
+// allowedRune reports whether the unicode policy allows the character. Marks are allowed for the
+// scripts that still combine characters after NFC
+func (cfg TextConfig) allowedRune(r rune) bool {
+	switch {
+	case unicode.IsLetter(r), unicode.IsMark(r), unicode.IsNumber(r), unicode.IsSpace(r):
+		return true
+	case r == '_' || r == '-' || r == '&':
+		return true
+	}
+	return strings.ContainsRune(cfg.Punctuation, r)
+}
+
+// Validator for the retailer and item descriptions with the character policy of the config
+func validText(v interface{}, params string) error {
+	// The binding check has already made sure this value is a string
+	text := reflect.ValueOf(v).String()
+	cfg := appConfig.Text
+	if cfg.Policy != textPolicyUnicode {
+		if !asciiTextPattern.MatchString(text) {
+			return errors.New("invalid characters")
+		}
+		return nil
+	}
+	if text == "" || strings.IndexFunc(text, func(r rune) bool { return !cfg.allowedRune(r) }) >= 0 {
+		return errors.New("invalid characters")
+	}
+	return nil
+}
+
+// normalizeText puts the retailer, item and discount descriptions in NFC, so a character typed as a letter plus a
+// combining accent is validated and scored the same as the single character
+func normalizeText(receipt *Receipt) {
+	receipt.Retailer = norm.NFC.String(receipt.Retailer)
+	for i := range receipt.Items {
+		receipt.Items[i].ShortDescription = norm.NFC.String(receipt.Items[i].ShortDescription)
+	}
+	for i := range receipt.Discounts {
+		receipt.Discounts[i].Description = norm.NFC.String(receipt.Discounts[i].Description)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestValidTextPolicies
+func TestValidTextPolicies(t *testing.T) {
+	tests := []struct {
+		text           string
+		ascii, unicode bool
+	}{
+		{"M&M Corner Market", true, true},
+		{"Mountain Dew 12PK", true, true},
+		{"Café Müller", false, true},
+		{"Señor Taco", false, true},
+		{"Trader Joe's", false, true},
+		{"Trader Joe’s", false, true},
+		{"St. Louis Bread Co.", false, true},
+		{"東京ストア", false, true},
+		{"Ѳеодоръ", false, true},
+		{"Pizza 🍕", false, false},
+		{"Total: 5", false, false},
+		{"<script>", false, false},
+		{"", false, false},
+	}
+	for _, test := range tests {
+		appConfig.Text = TextConfig{Policy: textPolicyASCII, Punctuation: "'’.,"}
+		assert.Equal(t, test.ascii, validText(test.text, "") == nil, "ascii %q", test.text)
+		appConfig.Text.Policy = textPolicyUnicode
+		assert.Equal(t, test.unicode, validText(test.text, "") == nil, "unicode %q", test.text)
+	}
+
+	// The punctuation is configurable
+	appConfig.Text = TextConfig{Policy: textPolicyUnicode}
+	assert.Error(t, validText("Trader Joe's", ""))
+	appConfig.Text.Punctuation = ":'"
+	assert.NoError(t, validText("Total: 5", ""))
+
+	assert.EqualError(t, TextConfig{Policy: "latin1"}.validate(), `the text policy must be "ascii" or "unicode"`)
+	appConfig = defaultConfig()
+}
+
+// TestNonASCIIPoints
+func TestNonASCIIPoints(t *testing.T) {
+	// Every letter and digit of any script is one point, accents do not add points
+	assert.Equal(t, int64(10), getCountAlphanumericPoints("Café Müller"))
+	assert.Equal(t, int64(9), getCountAlphanumericPoints("Señor Taco"))
+	assert.Equal(t, int64(5), getCountAlphanumericPoints("東京ストア"))
+	assert.Equal(t, int64(3), getCountAlphanumericPoints("٣٤٥"))
+	assert.Equal(t, int64(10), getCountAlphanumericPoints("Trader Joe’s"))
+
+	// Descriptions are measured in characters, "Müsli Bar" is 9 characters but 10 bytes
+	items := []Item{{ShortDescription: " Müsli Bar ", Price: "5.00"}}
+	assert.Equal(t, int64(1), getItemTrimmedLengthPoints(items))
+}
+
+// TestNormalizeText
+func TestNormalizeText(t *testing.T) {
+	// "e" followed by a combining acute accent becomes the single character é
+	receipt := validReceipt2
+	receipt.Retailer = "Cafe\u0301"
+	receipt.Items = []Item{{ShortDescription: "Cre\u0300me e\u0301te\u0301", Price: "5.00"}}
+	normalizeText(&receipt)
+	assert.Equal(t, "Caf\u00e9", receipt.Retailer)
+	assert.Equal(t, "Cr\u00e8me \u00e9t\u00e9", receipt.Items[0].ShortDescription)
+
+	// After NFC the description is 9 characters instead of 12 code points, a multiple of 3
+	assert.Equal(t, int64(1), getItemTrimmedLengthPoints(receipt.Items))
+}
+
+// TestUnicodeReceipts
+func TestUnicodeReceipts(t *testing.T) {
+	setup()
+	receipt := validReceipt2
+	receipt.Retailer = "Café Müller"
+
+	// The ascii policy is the default
+	router := newTestRouter(t, defaultConfig())
+	w := serveJSON(router, "POST", "/receipts/process", receipt)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The receipt is invalid.", w.Body.String())
+
+	cfg := defaultConfig()
+	cfg.Text.Policy = textPolicyUnicode
+	router = newTestRouter(t, cfg)
+	w = serveJSON(router, "POST", "/receipts/process", receipt)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Café Müller", receiptsMap["Receipt1"].Retailer)
+	// 10 points for the letters instead of the 14 of M&M Corner Market
+	assert.Equal(t, int64(105), receiptsMap["Receipt1"].Breakdown.Total)
+
+	teardown()
+}
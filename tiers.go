@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TierChange records a customer moving from one tier to another
+type TierChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// RollingPoints is what the customer had earned in the 12 months before the change
+	RollingPoints int64     `json:"rollingPoints"`
+	ChangedAt     time.Time `json:"changedAt"`
+}
+
+// TierAdjustment is the extra points the customer's tier adds on top of the base points
+type TierAdjustment struct {
+	Tier       string  `json:"tier"`
+	Multiplier float64 `json:"multiplier"`
+	Bonus      int64   `json:"bonus"`
+	Points     int64   `json:"points"`
+}
+
+type CustomerTierResponse struct {
+	CustomerID    string       `json:"customerId"`
+	Tier          string       `json:"tier"`
+	RollingPoints int64        `json:"rollingPoints"`
+	History       []TierChange `json:"history"`
+}
+
+// validateTiers checks the tiers are in ascending order of points with positive multipliers
+func validateTiers(levels []TierConfig) error {
+	for i, level := range levels {
+		if level.Name == "" || level.Multiplier <= 0 {
+			return errors.New("tiers need a name and a positive multiplier")
+		}
+		if i > 0 && level.MinPoints <= levels[i-1].MinPoints {
+			return errors.New("tiers must be in ascending order of minPoints")
+		}
+	}
+	return nil
+}
+
+// tierFor returns the highest tier the rolling points reach, nil if there are no tiers
+func tierFor(levels []TierConfig, rollingPoints int64) *TierConfig {
+	var tier *TierConfig
+	for i := range levels {
+		if rollingPoints >= levels[i].MinPoints {
+			tier = &levels[i]
+		}
+	}
+	return tier
+}
+
+// tierTracker keeps each customer's current tier and the history of changes
+type tierTracker struct {
+	mutex   sync.RWMutex
+	history map[string][]TierChange
+	now     func() time.Time
+}
+
+// newTierTracker creates a tracker with no customers
+func newTierTracker() *tierTracker {
+	return &tierTracker{history: make(map[string][]TierChange), now: time.Now}
+}
+
+// Stores the tier history in memory
+var tiers *tierTracker = newTierTracker()
+
+// current returns the customer's tier, empty before the first change
+func (t *tierTracker) current(customerID string) string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	history := t.history[customerID]
+	if len(history) == 0 {
+		return ""
+	}
+	return history[len(history)-1].To
+}
+
+// update works out the customer's tier from the last 12 months of earned points and records it if it changed
+func (t *tierTracker) update(customerID string) {
+	levels := appConfig.Tiers
+	if len(levels) == 0 {
+		return
+	}
+	now := t.now().UTC()
+	rollingPoints := ledger.earnedSince(customerID, now.AddDate(-1, 0, 0))
+	tier := tierFor(levels, rollingPoints)
+	name := ""
+	if tier != nil {
+		name = tier.Name
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	history := t.history[customerID]
+	from := ""
+	if len(history) > 0 {
+		from = history[len(history)-1].To
+	}
+	if name != from {
+		t.history[customerID] = append(history, TierChange{From: from, To: name, RollingPoints: rollingPoints, ChangedAt: now})
+	}
+}
+
+// adjustment applies the customer's current tier to the base points, nil if the customer has no tier
+func (t *tierTracker) adjustment(customerID string, base int64) *TierAdjustment {
+	name := t.current(customerID)
+	for _, level := range appConfig.Tiers {
+		if level.Name == name {
+			return newTierAdjustment(level.Name, level.Multiplier, level.Bonus, base)
+		}
+	}
+	return nil
+}
+
+// newTierAdjustment multiplies the base points and adds the bonus
+func newTierAdjustment(tier string, multiplier float64, bonus int64, base int64) *TierAdjustment {
+	// Round up like the item description rule
+	adjusted := int64(math.Ceil(float64(base)*multiplier)) + bonus
+	return &TierAdjustment{Tier: tier, Multiplier: multiplier, Bonus: bonus, Points: adjusted - base}
+}
+
+// getCustomerTier returns the customer's tier and the history of changes
+func getCustomerTier(c *gin.Context) {
+	customer, ok := findCustomer(c.Param("id"), requestOwner(c))
+	if !ok {
+		c.String(http.StatusNotFound, "No customer found for that ID.")
+		return
+	}
+
+	// Points drop out of the 12 month window over time, so the tier may have gone down since the last receipt
+	tiers.update(customer.ID)
+
+	tiers.mutex.RLock()
+	history := append([]TierChange{}, tiers.history[customer.ID]...)
+	tiers.mutex.RUnlock()
+	c.JSON(http.StatusOK, CustomerTierResponse{
+		CustomerID:    customer.ID,
+		Tier:          tiers.current(customer.ID),
+		RollingPoints: ledger.earnedSince(customer.ID, tiers.now().UTC().AddDate(-1, 0, 0)),
+		History:       history,
+	})
+}
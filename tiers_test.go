@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Bronze for everyone, Silver from 100 points, Gold from 200 points
+var testTiers = []TierConfig{
+	{Name: "Bronze", MinPoints: 0, Multiplier: 1},
+	{Name: "Silver", MinPoints: 100, Multiplier: 1.5},
+	{Name: "Gold", MinPoints: 200, Multiplier: 2, Bonus: 10},
+}
+
+// TestValidateTiers
+func TestValidateTiers(t *testing.T) {
+	assert.NoError(t, validateTiers(testTiers))
+	assert.NoError(t, validateTiers(nil))
+	assert.Error(t, validateTiers([]TierConfig{{Name: "Gold", MinPoints: 200, Multiplier: 2}, {Name: "Silver", MinPoints: 100, Multiplier: 1.5}}))
+	assert.Error(t, validateTiers([]TierConfig{{Name: "Zero", Multiplier: 0}}))
+}
+
+// TestTierFor
+func TestTierFor(t *testing.T) {
+	assert.Equal(t, "Bronze", tierFor(testTiers, 99).Name)
+	assert.Equal(t, "Silver", tierFor(testTiers, 100).Name)
+	assert.Equal(t, "Gold", tierFor(testTiers, 5000).Name)
+	assert.Nil(t, tierFor(testTiers[1:], 50))
+}
+
+// TestTierMultiplierAndHistory
+func TestTierMultiplierAndHistory(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.Tiers = testTiers
+	router := newTestRouter(t, cfg)
+	serveJSON(router, "POST", "/customers", CreateCustomerRequest{Name: "Ada"})
+
+	// New customers start in the lowest tier, 109 points as Bronze moves them up to Silver
+	assert.Equal(t, "Bronze", tiers.current("Customer1"))
+	receipt2 := validReceipt2
+	receipt2.CustomerID = "Customer1"
+	serveJSON(router, "POST", "/receipts/process", receipt2)
+	assert.Equal(t, "Silver", tiers.current("Customer1"))
+
+	// 28 points as Silver are multiplied by 1.5 and rounded up
+	receipt1 := validReceipt1
+	receipt1.CustomerID = "Customer1"
+	serveJSON(router, "POST", "/receipts/process", receipt1)
+
+	w := serveJSON(router, "GET", "/receipts/Receipt2/breakdown", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var breakdown PointsBreakdown
+	json.Unmarshal(w.Body.Bytes(), &breakdown)
+	assert.Len(t, breakdown.Rules, 7)
+	assert.Equal(t, int64(28), breakdown.Base)
+	assert.Equal(t, &TierAdjustment{Tier: "Silver", Multiplier: 1.5, Points: 14}, breakdown.Tier)
+	assert.Equal(t, int64(42), breakdown.Total)
+
+	// The points endpoint and the ledger both use the adjusted total
+	w = serveJSON(router, "GET", "/receipts/Receipt2/points", nil)
+	expectedResponse, _ := json.Marshal(PointsGeneratedResponse{Points: 42, RuleVersion: "1"})
+	assert.Equal(t, string(expectedResponse), w.Body.String())
+	assert.Equal(t, int64(151), ledger.balance("Customer1"))
+
+	w = serveJSON(router, "GET", "/customers/Customer1/tier", nil)
+	var response CustomerTierResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, "Silver", response.Tier)
+	assert.Equal(t, int64(151), response.RollingPoints)
+	assert.Len(t, response.History, 2)
+	assert.Equal(t, TierChange{From: "", To: "Bronze", RollingPoints: 0, ChangedAt: response.History[0].ChangedAt}, response.History[0])
+	assert.Equal(t, TierChange{From: "Bronze", To: "Silver", RollingPoints: 109, ChangedAt: response.History[1].ChangedAt}, response.History[1])
+
+	teardown()
+}
+
+// TestTierDropsWhenPointsLeaveWindow
+func TestTierDropsWhenPointsLeaveWindow(t *testing.T) {
+	setup()
+	appConfig.Tiers = testTiers
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ledger.now = func() time.Time { return start }
+	tiers.now = func() time.Time { return start }
+
+	ledger.append(LedgerEntry{CustomerID: "Customer1", Type: entryEarn, Points: 250, ReceiptID: "Receipt1"})
+	tiers.update("Customer1")
+	assert.Equal(t, "Gold", tiers.current("Customer1"))
+	assert.Equal(t, &TierAdjustment{Tier: "Gold", Multiplier: 2, Bonus: 10, Points: 20}, tiers.adjustment("Customer1", 10))
+
+	tiers.now = func() time.Time { return start.AddDate(1, 0, 1) }
+	tiers.update("Customer1")
+	assert.Equal(t, "Bronze", tiers.current("Customer1"))
+	assert.Len(t, tiers.history["Customer1"], 2)
+
+	teardown()
+}
+
+// TestNoTiersConfigured
+func TestNoTiersConfigured(t *testing.T) {
+	setup()
+	ledger.append(LedgerEntry{CustomerID: "Customer1", Type: entryEarn, Points: 250})
+	tiers.update("Customer1")
+	assert.Equal(t, "", tiers.current("Customer1"))
+	assert.Nil(t, tiers.adjustment("Customer1", 10))
+	teardown()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	// The IANA time zones are built in so the service does not depend on the zoneinfo files of the host
+	_ "time/tzdata"
+)
+
+// Layout of the purchase date and time together
+const purchaseLayout = "2006-01-02 15:04"
+
+// TimeZonesConfig sets the time zone of the stores, purchase dates and times are read in UTC when it is empty
+type TimeZonesConfig struct {
+	// Default is the IANA time zone of the stores that have none of their own, like "America/Chicago"
+	Default string `json:"default"`
+	// Retailers maps a retailer name to the IANA time zone of its stores, names are matched like the rule overrides
+	Retailers map[string]string `json:"retailers"`
+}
+
+// loadTimeZone returns the IANA time zone with the name, UTC when it is empty. The time zone of the host
+// is not accepted so the points do not depend on where the service runs
+func loadTimeZone(name string) (*time.Location, error) {
+	location, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %s", name)
+	}
+	return location, nil
+}
+
+// validate checks every time zone is a known IANA name
+func (cfg TimeZonesConfig) validate() error {
+	if _, err := loadTimeZone(cfg.Default); err != nil {
+		return fmt.Errorf("the default time zone: %w", err)
+	}
+	for retailer, name := range cfg.Retailers {
+		if _, err := loadTimeZone(name); err != nil || name == "" {
+			return fmt.Errorf("unknown time zone %q for retailer %s", name, retailer)
+		}
+	}
+	return nil
+}
+
+// storeTimeZone returns the name of the time zone the receipt was printed in. The receipt's own time zone
+// comes first, then the one of its retailer in the registry, then the one of its printed or canonical
+// name in the config, then the default
+func storeTimeZone(receipt Receipt, cfg TimeZonesConfig) string {
+	if receipt.TimeZone != "" {
+		return receipt.TimeZone
+	}
+	if retailer, ok := retailers.get(receipt.RetailerID, receipt.Tenant); ok && retailer.TimeZone != "" {
+		return retailer.TimeZone
+	}
+	for retailer, name := range cfg.Retailers {
+		if normalizeRetailer(retailer) == normalizeRetailer(receipt.Retailer) || receipt.RetailerName != "" && normalizeRetailer(retailer) == normalizeRetailer(receipt.RetailerName) {
+			return name
+		}
+	}
+	return cfg.Default
+}
+
+// purchaseInstant reads the purchase date and time as the wall clock of the store. A time that is skipped
+// when the clocks go forward is moved forward by the gap, and a time that happens twice when they go back
+// is the first of the two
+func purchaseInstant(receipt Receipt, cfg TimeZonesConfig) (time.Time, error) {
+	location, err := loadTimeZone(storeTimeZone(receipt, cfg))
+	if err != nil {
+		return time.Time{}, err
+	}
+	wall, err := time.Parse(purchaseLayout, receipt.PurchaseDate+" "+receipt.PurchaseTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// time.Date does not say which instant it picks around a transition, so try the offsets in effect a day
+	// before and a day after, there is at most one transition in between
+	_, before := wall.Add(-24 * time.Hour).In(location).Zone()
+	_, after := wall.Add(24 * time.Hour).In(location).Zone()
+	for _, offset := range []int{before, after} {
+		instant := wall.Add(-time.Duration(offset) * time.Second).In(location)
+		if instant.Format(purchaseLayout) == wall.Format(purchaseLayout) {
+			return instant, nil
+		}
+	}
+	// The time was skipped, reading it with the offset from before the gap moves it forward by the gap
+	return wall.Add(-time.Duration(before) * time.Second).In(location), nil
+}
+
+// localPurchase returns the purchase date and time on the store's clock that the rules are evaluated with.
+// Receipts without a zoned instant use the date and time as they were given
+func (receipt Receipt) localPurchase() (string, string) {
+	if receipt.PurchasedAt.IsZero() {
+		return receipt.PurchaseDate, receipt.PurchaseTime
+	}
+	return receipt.PurchasedAt.Format("2006-01-02"), receipt.PurchasedAt.Format("15:04")
+}
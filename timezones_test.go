@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// zonedReceipt returns validReceipt2 bought at the date and time in the time zone
+func zonedReceipt(date string, purchaseTime string, timeZone string) Receipt {
+	receipt := validReceipt2
+	receipt.PurchaseDate = date
+	receipt.PurchaseTime = purchaseTime
+	receipt.TimeZone = timeZone
+	return receipt
+}
+
+// TestPurchaseInstant
+func TestPurchaseInstant(t *testing.T) {
+	tests := []struct {
+		name, date, time, timeZone, expected string
+	}{
+		{"no time zone", "2024-03-10", "02:30", "", "2024-03-10T02:30:00Z"},
+		{"standard time", "2024-01-15", "14:30", "America/New_York", "2024-01-15T14:30:00-05:00"},
+		{"daylight time", "2024-07-15", "14:30", "America/New_York", "2024-07-15T14:30:00-04:00"},
+		// 02:00 to 03:00 is skipped when the clocks go forward, the time moves forward by the gap
+		{"spring forward gap", "2024-03-10", "02:30", "America/New_York", "2024-03-10T03:30:00-04:00"},
+		{"after spring forward", "2024-03-10", "03:30", "America/New_York", "2024-03-10T03:30:00-04:00"},
+		// 01:00 to 02:00 happens twice when the clocks go back, the first one is used
+		{"fall back overlap", "2024-11-03", "01:30", "America/New_York", "2024-11-03T01:30:00-04:00"},
+		{"after fall back", "2024-11-03", "02:30", "America/New_York", "2024-11-03T02:30:00-05:00"},
+		{"southern hemisphere", "2024-10-06", "02:30", "Australia/Sydney", "2024-10-06T03:30:00+11:00"},
+		// Samoa skipped the whole of 30 December 2011 when it moved across the date line
+		{"skipped day", "2011-12-30", "12:00", "Pacific/Apia", "2011-12-31T12:00:00+14:00"},
+	}
+	for _, test := range tests {
+		instant, err := purchaseInstant(zonedReceipt(test.date, test.time, test.timeZone), TimeZonesConfig{})
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, instant.Format(time.RFC3339), test.name)
+	}
+
+	_, err := purchaseInstant(zonedReceipt("2024-01-15", "14:30", "Mars/Olympus"), TimeZonesConfig{})
+	assert.EqualError(t, err, "unknown time zone Mars/Olympus")
+	_, err = purchaseInstant(zonedReceipt("2024-01-15", "14:30", "Local"), TimeZonesConfig{})
+	assert.EqualError(t, err, "unknown time zone Local")
+}
+
+// TestStoreTimeZone
+func TestStoreTimeZone(t *testing.T) {
+	cfg := TimeZonesConfig{Default: "America/Chicago", Retailers: map[string]string{"m&m  corner MARKET": "America/Denver"}}
+	receipt := validReceipt2
+	assert.Equal(t, "America/Denver", storeTimeZone(receipt, cfg))
+	receipt.Retailer = "Target"
+	assert.Equal(t, "America/Chicago", storeTimeZone(receipt, cfg))
+	receipt.TimeZone = "Europe/Paris"
+	assert.Equal(t, "Europe/Paris", storeTimeZone(receipt, cfg))
+
+	assert.NoError(t, cfg.validate())
+	cfg.Retailers["Target"] = "Europe/Atlantis"
+	assert.EqualError(t, cfg.validate(), `unknown time zone "Europe/Atlantis" for retailer Target`)
+	assert.EqualError(t, TimeZonesConfig{Default: "Local"}.validate(), "the default time zone: unknown time zone Local")
+}
+
+// TestRulesUseStoreTime
+func TestRulesUseStoreTime(t *testing.T) {
+	setup()
+	cfg := defaultConfig()
+	cfg.TimeZones.Default = "America/New_York"
+	router := newTestRouter(t, cfg)
+
+	score := func(receipt Receipt) ReceiptScoreResponse {
+		var response ReceiptScoreResponse
+		w := serveJSON(router, "POST", "/receipts/score", receipt)
+		assert.Equal(t, http.StatusOK, w.Code)
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+	rulePoints := func(response ReceiptScoreResponse, rule string) int64 {
+		for _, points := range response.Breakdown.Rules {
+			if points.Rule == rule {
+				return points.Points
+			}
+		}
+		return -1
+	}
+
+	// 13:30 and 15:59 on the store's clock, the afternoon rule does not depend on the offset
+	assert.Equal(t, int64(0), rulePoints(score(zonedReceipt("2024-03-10", "13:30", "")), "afternoonPurchase"))
+	assert.Equal(t, int64(10), rulePoints(score(zonedReceipt("2024-11-03", "15:59", "")), "afternoonPurchase"))
+
+	// The skipped day in Samoa is read as the 31st, an odd day
+	assert.Equal(t, int64(0), rulePoints(score(zonedReceipt("2011-12-30", "12:00", "")), "oddPurchaseDay"))
+	assert.Equal(t, int64(6), rulePoints(score(zonedReceipt("2011-12-30", "12:00", "Pacific/Apia")), "oddPurchaseDay"))
+
+	w := serveJSON(router, "POST", "/receipts/process", zonedReceipt("2024-03-10", "02:30", "America/Los_Angeles"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2024-03-10T03:30:00-07:00", receiptsMap["Receipt1"].PurchasedAt.Format(time.RFC3339))
+
+	w = serveJSON(router, "POST", "/receipts/process", zonedReceipt("2024-03-10", "02:30", "Mars/Olympus"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The receipt is invalid: unknown time zone Mars/Olympus.", w.Body.String())
+
+	teardown()
+}
+
+// TestExpressionsUseStoreTime
+func TestExpressionsUseStoreTime(t *testing.T) {
+	receipt := zonedReceipt("2024-03-10", "02:30", "America/New_York")
+	receipt.PurchasedAt, _ = purchaseInstant(receipt, TimeZonesConfig{})
+	assert.Equal(t, int64(3), runExpression(t, `true => hour`, receipt))
+	assert.Equal(t, int64(1), runExpression(t, `time == "03:30" => 1`, receipt))
+}
@@ -21,6 +21,7 @@ func TestValidTimeWithValidTimeandDate(t *testing.T) {
 
 	validator.SetValidationFunc("validTime", validTime)
 	validator.SetValidationFunc("validDate", validDate)
+	validator.SetValidationFunc("validText", validText)
 	err := validator.Validate(receipt)
 	if err != nil {
 		t.Fatalf(`validTime("05:31") = %v, expected no error`, err)
@@ -43,6 +44,7 @@ func TestValidTimeWithValidTimeInvalidDate(t *testing.T) {
 
 	validator.SetValidationFunc("validTime", validTime)
 	validator.SetValidationFunc("validDate", validDate)
+	validator.SetValidationFunc("validText", validText)
 	err := validator.Validate(receipt)
 	if err == nil {
 		t.Fatalf(`validDate("2000-02-30") = %v, expected invalid date error`, err)
@@ -65,6 +67,7 @@ func TestValidTimeWithInvalidTimeValidDate(t *testing.T) {
 
 	validator.SetValidationFunc("validTime", validTime)
 	validator.SetValidationFunc("validDate", validDate)
+	validator.SetValidationFunc("validText", validText)
 	err := validator.Validate(receipt)
 	if err == nil {
 		t.Fatalf(`validDate("200-01-30") = %v, expected invalid date and invalid time error`, err)
@@ -88,6 +91,7 @@ func TestValidTimeWithInvalidTimeInvalidDate(t *testing.T) {
 
 	validator.SetValidationFunc("validTime", validTime)
 	validator.SetValidationFunc("validDate", validDate)
+	validator.SetValidationFunc("validText", validText)
 	err := validator.Validate(receipt)
 	if err == nil {
 		t.Fatalf(`validDate("200-01-30") = %v, expected invalid date and invalid time error`, err)
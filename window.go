@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Clock tells the current time, the tests replace it so the acceptance window is deterministic
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// The clock the purchase times are checked against
+var clock Clock = systemClock{}
+
+// PurchaseWindowConfig bounds how far the purchase can be from now, a bound that is left out is off
+type PurchaseWindowConfig struct {
+	// MaxFutureDays is how far in the future a purchase can be, to allow for store clocks that are ahead.
+	// 0 rejects every purchase after now
+	MaxFutureDays *int `json:"maxFutureDays"`
+	// MaxAgeDays is how long ago a purchase can be
+	MaxAgeDays *int `json:"maxAgeDays"`
+}
+
+// validate checks the bounds are not negative
+func (cfg PurchaseWindowConfig) validate() error {
+	if cfg.MaxFutureDays != nil && *cfg.MaxFutureDays < 0 || cfg.MaxAgeDays != nil && *cfg.MaxAgeDays < 0 {
+		return errors.New("maxFutureDays and maxAgeDays can not be negative")
+	}
+	return nil
+}
+
+// check returns why the purchase is outside the window, nil if it is inside
+func (cfg PurchaseWindowConfig) check(purchasedAt time.Time, now time.Time) error {
+	if cfg.MaxFutureDays != nil && purchasedAt.After(now.AddDate(0, 0, *cfg.MaxFutureDays)) {
+		if *cfg.MaxFutureDays == 0 {
+			return errors.New("the purchase is in the future")
+		}
+		return fmt.Errorf("the purchase is more than %s in the future", countDays(*cfg.MaxFutureDays))
+	}
+	if cfg.MaxAgeDays != nil && purchasedAt.Before(now.AddDate(0, 0, -*cfg.MaxAgeDays)) {
+		return fmt.Errorf("the purchase is more than %s old", countDays(*cfg.MaxAgeDays))
+	}
+	return nil
+}
+
+// countDays writes "1 day" or "n days"
+func countDays(days int) string {
+	if days == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fixedClock always tells the same time
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// days returns a bound of the purchase window
+func days(n int) *int {
+	return &n
+}
+
+// TestPurchaseWindowCheck
+func TestPurchaseWindowCheck(t *testing.T) {
+	now := time.Date(2022, time.March, 20, 12, 0, 0, 0, time.UTC)
+	window := PurchaseWindowConfig{MaxFutureDays: days(1), MaxAgeDays: days(90)}
+	assert.NoError(t, window.check(now, now))
+	assert.NoError(t, window.check(now.Add(24*time.Hour), now))
+	assert.NoError(t, window.check(now.AddDate(0, 0, -90), now))
+	assert.EqualError(t, window.check(now.Add(24*time.Hour+time.Minute), now), "the purchase is more than 1 day in the future")
+	assert.EqualError(t, window.check(now.AddDate(0, 0, -90).Add(-time.Minute), now), "the purchase is more than 90 days old")
+
+	// Bounds that are left out are off
+	assert.NoError(t, PurchaseWindowConfig{}.check(time.Date(2099, time.January, 1, 0, 0, 0, 0, time.UTC), now))
+	assert.NoError(t, PurchaseWindowConfig{}.check(time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC), now))
+	assert.EqualError(t, PurchaseWindowConfig{MaxAgeDays: days(-1)}.validate(), "maxFutureDays and maxAgeDays can not be negative")
+
+	// 0 days in the future allows purchases up to now
+	window = PurchaseWindowConfig{MaxFutureDays: days(0)}
+	assert.NoError(t, window.check(now.Add(-time.Hour), now))
+	assert.NoError(t, window.check(now, now))
+	assert.EqualError(t, window.check(now.Add(time.Minute), now), "the purchase is in the future")
+}
+
+// TestPurchaseWindow
+func TestPurchaseWindow(t *testing.T) {
+	setup()
+	clock = fixedClock(time.Date(2022, time.March, 21, 12, 0, 0, 0, time.UTC))
+	cfg := newTenantConfig()
+	cfg.PurchaseWindow = PurchaseWindowConfig{MaxFutureDays: days(1), MaxAgeDays: days(365)}
+	cfg.Tenants.Tenants["globex"] = TenantConfig{PurchaseWindow: &PurchaseWindowConfig{MaxAgeDays: days(30)}}
+	router := newTestRouter(t, cfg)
+
+	// validReceipt2 was bought on 2022-03-20 at 14:33
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/receipts/process", validReceipt2).Code)
+
+	tests := map[string]string{
+		"2099-01-01": "The receipt is invalid: the purchase is more than 1 day in the future.",
+		"2022-03-23": "The receipt is invalid: the purchase is more than 1 day in the future.",
+		"1900-01-01": "The receipt is invalid: the purchase is more than 365 days old.",
+	}
+	for date, expected := range tests {
+		receipt := validReceipt2
+		receipt.PurchaseDate = date
+		w := serveJSON(router, "POST", "/receipts/process", receipt)
+		assert.Equal(t, http.StatusBadRequest, w.Code, date)
+		assert.Equal(t, expected, w.Body.String(), date)
+	}
+
+	// The store's time zone decides the instant, 23:30 in Auckland on the 22nd is before noon UTC on the 22nd
+	receipt := zonedReceipt("2022-03-22", "23:30", "Pacific/Auckland")
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/receipts/process", receipt).Code)
+	receipt.TimeZone = "America/Chicago"
+	assert.Equal(t, http.StatusBadRequest, serveJSON(router, "POST", "/receipts/process", receipt).Code)
+
+	// globex has its own window without a future bound
+	receipt = validReceipt2
+	receipt.PurchaseDate = "2022-01-01"
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/t/acme/receipts/process", receipt).Code)
+	w := serveJSON(router, "POST", "/t/globex/receipts/process", receipt)
+	assert.Equal(t, "The receipt is invalid: the purchase is more than 30 days old.", w.Body.String())
+	receipt.PurchaseDate = "2099-01-01"
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/t/globex/receipts/process", receipt).Code)
+
+	teardown()
+}
+
+// TestPurchaseWindowNotAfterNow
+func TestPurchaseWindowNotAfterNow(t *testing.T) {
+	setup()
+	clock = fixedClock(time.Date(2022, time.March, 20, 18, 0, 0, 0, time.UTC))
+	cfg := defaultConfig()
+	cfg.PurchaseWindow = PurchaseWindowConfig{MaxFutureDays: days(0)}
+	router := newTestRouter(t, cfg)
+
+	// validReceipt2 was bought earlier the same day, at 14:33
+	assert.Equal(t, http.StatusOK, serveJSON(router, "POST", "/receipts/process", validReceipt2).Code)
+	receipt := validReceipt2
+	receipt.PurchaseDate = "2022-03-21"
+	w := serveJSON(router, "POST", "/receipts/process", receipt)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The receipt is invalid: the purchase is in the future.", w.Body.String())
+
+	teardown()
+}